@@ -19,14 +19,28 @@ const usage = `Usage: %s [options]
 
 func parseFlags() *config {
 	var (
-		provider      = flag.String("provider", os.Getenv("PROVIDER"), "The DNS provider to register the domain names with (env: `PROVIDER`, default: `cloudflare`, oneOf: [`cloudflare`, `dryrun`])")
+		provider      = flag.String("provider", os.Getenv("PROVIDER"), "The DNS provider to register the domain names with (env: `PROVIDER`, default: `cloudflare`, oneOf: [`cloudflare`, `dryrun`, `rfc2136`])")
 		accountName   = flag.String("account-name", os.Getenv("ACCOUNT_NAME"), "The account-name (or equivalent) to be used for authenticating with the DNS provider (env: `ACCOUNT_NAME`)")
 		accountSecret = flag.String("account-secret", os.Getenv("ACCOUNT_SECRET"), "The account-secret (or equivalent) to be used for authenticating with the DNS provider (env: `ACCOUNT_SECRET`)")
-		dnsContent    = flag.String("dns-content", os.Getenv("DNS_CONTENT"), "The IP address to be added to the DNS content (env: `DNS_CONTENT`, default: `container`, oneOf: [`container`, `<ipv4>`])")
-		dockerLabel   = flag.String("docker-label", os.Getenv("DOCKER_LABEL"), "The docker label that contains the domain name (env: `DOCKER_LABEL`, default: `dd-dns.hostname`)")
+		dnsContent    = flag.String("dns-content", os.Getenv("DNS_CONTENT"), "The IP addresses to be added to the DNS content (env: `DNS_CONTENT`, default: `container`, oneOf: [`container`, `tailscale`, `<ip>[,<ip>...]`])")
+		dockerLabel   = flag.String("docker-label", os.Getenv("DOCKER_LABEL"), "The docker label that contains the domain names, indexed variants (eg: `dd-dns.hostname.1`) are also read (env: `DOCKER_LABEL`, default: `dd-dns.hostname`)")
 		storeName     = flag.String("store", os.Getenv("STORE"), "The store implemenation that persists the internal state (env: `STORE`, default: `memory`, oneOf: [`memory`, `boltdb`])")
 		debugLogger   = flag.Bool("debug-logger", false, "Set to use human readable logs, rather than structured logs (default: `false`)")
 		dataDirectory = flag.String("data-directory", os.Getenv("DATA_DIRECTORY"), "The directory where any persistent state is stored (env: `DATA_DIRECTORY`, default: `pwd`)")
+		rfc2136Server = flag.String("rfc2136-server", os.Getenv("RFC2136_SERVER"), "The nameserver (host:port) that receives the dynamic updates of the rfc2136 provider (env: `RFC2136_SERVER`)")
+		tsigAlgorithm = flag.String("rfc2136-tsig-algorithm", os.Getenv("RFC2136_TSIG_ALGORITHM"), "The algorithm used to sign the dynamic updates of the rfc2136 provider (env: `RFC2136_TSIG_ALGORITHM`, default: `hmac-sha256`, oneOf: [`hmac-sha1`, `hmac-sha224`, `hmac-sha256`, `hmac-sha384`, `hmac-sha512`])")
+		resync        = flag.String("resync-interval", os.Getenv("RESYNC_INTERVAL"), "The interval at which the full docker state is synced with the DNS provider, 0 disables it (env: `RESYNC_INTERVAL`, default: `0s`, minimum: `1m`)")
+		ownerID       = flag.String("owner-id", os.Getenv("OWNER_ID"), "The id of this instance, which is stored in TXT records to only modify records this instance owns, empty disables it (env: `OWNER_ID`)")
+		adoptRecords  = flag.Bool("adopt-records", os.Getenv("ADOPT_RECORDS") == "true", "Set to claim ownership of the existing records of the running containers that have no owner yet at startup, requires `owner-id` (env: `ADOPT_RECORDS`, default: `false`)")
+		rateLimit     = flag.String("provider-rate-limit", os.Getenv("PROVIDER_RATE_LIMIT"), "The maximum number of calls per second to the DNS provider, 0 disables the limit (env: `PROVIDER_RATE_LIMIT`, default: `4` for cloudflare, `20` for rfc2136, `0` for dryrun)")
+		maxRetries    = flag.String("provider-max-retries", os.Getenv("PROVIDER_MAX_RETRIES"), "The number of times a call to the DNS provider that failed with a rate limit, server or network error is retried (env: `PROVIDER_MAX_RETRIES`, default: `5`)")
+		gracePeriod   = flag.String("grace-period", os.Getenv("GRACE_PERIOD"), "The time the records of a stopped container are kept, so a restart doesn't remove them, 0 disables it (env: `GRACE_PERIOD`, default: `0s`, minimum: `1s`)")
+		swarmServices = flag.String("swarm-services", os.Getenv("SWARM_SERVICES"), "Set to publish the swarm services with a hostname label, using their virtual IP or the IPs of the nodes that run their tasks. Requires a manager node, empty disables it (env: `SWARM_SERVICES`, oneOf: [`vip`, `nodes`])")
+		sources       = flag.String("hostname-sources", os.Getenv("HOSTNAME_SOURCES"), "The comma separated list of sources the hostnames of a container are read from (env: `HOSTNAME_SOURCES`, default: `label`, anyOf: [`label`, `traefik`, `caddy`])")
+		hostnameTmpl  = flag.String("hostname-template", os.Getenv("HOSTNAME_TEMPLATE"), "The go template that renders the hostnames of the containers with the `dd-dns.enable=true` label (eg: `{{.Service}}.{{.Project}}.example.com`), empty disables it (env: `HOSTNAME_TEMPLATE`)")
+		allowed       = flag.String("allowed-domains", os.Getenv("ALLOWED_DOMAINS"), "The comma separated list of domains (which include their subdomains) or globs (eg: `*.apps.example.com`) dd-dns may publish, empty allows all domains (env: `ALLOWED_DOMAINS`)")
+		denied        = flag.String("denied-domains", os.Getenv("DENIED_DOMAINS"), "The comma separated list of domains (which include their subdomains) or globs (eg: `www.example.com`) dd-dns may never publish, this takes precedence over `allowed-domains` (env: `DENIED_DOMAINS`)")
+		network       = flag.String("default-network", os.Getenv("DEFAULT_NETWORK"), "The docker network of which the container IP is published, unless overridden by the `dd-dns.network` label (env: `DEFAULT_NETWORK`, default: first network of the container)")
 	)
 
 	flag.Usage = func() {
@@ -37,13 +51,27 @@ func parseFlags() *config {
 	flag.Parse()
 
 	return &config{
-		Provider:      *provider,
-		AccountName:   *accountName,
-		AccountSecret: *accountSecret,
-		DNSContent:    *dnsContent,
-		DockerLabel:   *dockerLabel,
-		Store:         *storeName,
-		DebugLogger:   *debugLogger,
-		DataDirectory: *dataDirectory,
+		Provider:             *provider,
+		AccountName:          *accountName,
+		AccountSecret:        *accountSecret,
+		DNSContent:           *dnsContent,
+		DockerLabel:          *dockerLabel,
+		Store:                *storeName,
+		DebugLogger:          *debugLogger,
+		DataDirectory:        *dataDirectory,
+		RFC2136Server:        *rfc2136Server,
+		RFC2136TSIGAlgorithm: *tsigAlgorithm,
+		DefaultNetwork:       *network,
+		ResyncInterval:       *resync,
+		OwnerID:              *ownerID,
+		AdoptRecords:         *adoptRecords,
+		ProviderRateLimit:    *rateLimit,
+		ProviderMaxRetries:   *maxRetries,
+		GracePeriod:          *gracePeriod,
+		SwarmServices:        *swarmServices,
+		HostnameSources:      *sources,
+		HostnameTemplate:     *hostnameTmpl,
+		AllowedDomains:       *allowed,
+		DeniedDomains:        *denied,
 	}
 }
@@ -1,37 +1,77 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net"
 	"os"
+	"path"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
+	"github.com/wdullaer/dd-dns/stringslice"
+	"github.com/wdullaer/dd-dns/types"
 	"go.uber.org/zap/zapcore"
 )
 
 const (
 	providerCloudflare  string = "cloudflare"
 	providerDryrun      string = "dryrun"
+	providerRFC2136     string = "rfc2136"
 	storeMemory         string = "memory"
 	storeBoltdb         string = "boltdb"
 	dnsContentContainer string = "container"
+	dnsContentTailscale string = "tailscale"
+	swarmServicesVIP    string = "vip"
+	swarmServicesNodes  string = "nodes"
+	// minResyncInterval prevents the full sync (which lists every record at the provider) from running in a tight loop
+	minResyncInterval = time.Minute
+	// minGracePeriod is the smallest grace period, since pending removals are checked every second
+	minGracePeriod = time.Second
+	// defaultMaxRetries is the number of times a failed call to the DNS provider is retried
+	defaultMaxRetries = "5"
 )
 
+// defaultRateLimits contains the default number of calls per second to each DNS provider
+// The Cloudflare API allows 1200 requests per 5 minutes, rfc2136 servers and dryrun have no documented limit
+var defaultRateLimits = map[string]string{
+	providerCloudflare: "4",
+	providerDryrun:     "0",
+	providerRFC2136:    "20",
+}
+
 type config struct {
-	Provider      string `json:"provider"`
-	AccountName   string `json:"account-name"`
-	AccountSecret string `json:"account-secret"` //nolint:gosec
-	DNSContent    string `json:"dns-content"`
-	DockerLabel   string `json:"docker-label"`
-	Store         string `json:"store"`
-	DataDirectory string `json:"data-directory"`
-	DebugLogger   bool   `json:"debug-logger"`
-	// TODO: Add config entry for default docker network to use when DNSContent is container
+	Provider             string `json:"provider"`
+	AccountName          string `json:"account-name"`
+	AccountSecret        string `json:"account-secret"` //nolint:gosec
+	DNSContent           string `json:"dns-content"`
+	DockerLabel          string `json:"docker-label"`
+	Store                string `json:"store"`
+	DataDirectory        string `json:"data-directory"`
+	DebugLogger          bool   `json:"debug-logger"`
+	RFC2136Server        string `json:"rfc2136-server"`
+	RFC2136TSIGAlgorithm string `json:"rfc2136-tsig-algorithm"`
+	DefaultNetwork       string `json:"default-network"`
+	ResyncInterval       string `json:"resync-interval"`
+	OwnerID              string `json:"owner-id"`
+	AdoptRecords         bool   `json:"adopt-records"`
+	ProviderRateLimit    string `json:"provider-rate-limit"`
+	ProviderMaxRetries   string `json:"provider-max-retries"`
+	GracePeriod          string `json:"grace-period"`
+	SwarmServices        string `json:"swarm-services"`
+	HostnameSources      string `json:"hostname-sources"`
+	HostnameTemplate     string `json:"hostname-template"`
+	AllowedDomains       string `json:"allowed-domains"`
+	DeniedDomains        string `json:"denied-domains"`
 }
 
 func (c *config) String() string {
 	return fmt.Sprintf(
-		"{\"provider\": \"%s\", \"account-name\": \"%s\", \"account-secret\": \"%s\", \"dns-content\": \"%s\", \"dns-label\": \"%s\", \"store\": \"%s\", \"debug-logger\": \"%t\", \"data-directory\": \"%s\"}",
+		"{\"provider\": \"%s\", \"account-name\": \"%s\", \"account-secret\": \"%s\", \"dns-content\": \"%s\", \"dns-label\": \"%s\", \"store\": \"%s\", \"debug-logger\": \"%t\", \"data-directory\": \"%s\", \"rfc2136-server\": \"%s\", \"rfc2136-tsig-algorithm\": \"%s\", \"default-network\": \"%s\", \"resync-interval\": \"%s\", \"owner-id\": \"%s\", \"adopt-records\": \"%t\", \"provider-rate-limit\": \"%s\", \"provider-max-retries\": \"%s\", \"grace-period\": \"%s\", \"swarm-services\": \"%s\", \"hostname-sources\": \"%s\", \"hostname-template\": \"%s\", \"allowed-domains\": \"%s\", \"denied-domains\": \"%s\"}",
 		c.Provider,
 		c.AccountName,
 		"****",
@@ -40,6 +80,20 @@ func (c *config) String() string {
 		c.Store,
 		c.DebugLogger,
 		c.DataDirectory,
+		c.RFC2136Server,
+		c.RFC2136TSIGAlgorithm,
+		c.DefaultNetwork,
+		c.ResyncInterval,
+		c.OwnerID,
+		c.AdoptRecords,
+		c.ProviderRateLimit,
+		c.ProviderMaxRetries,
+		c.GracePeriod,
+		c.SwarmServices,
+		c.HostnameSources,
+		c.HostnameTemplate,
+		c.AllowedDomains,
+		c.DeniedDomains,
 	)
 }
 
@@ -55,6 +109,20 @@ func (c config) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	enc.AddString("store", c.Store)
 	enc.AddBool("debug-logger", c.DebugLogger)
 	enc.AddString("data-directory", c.DataDirectory)
+	enc.AddString("rfc2136-server", c.RFC2136Server)
+	enc.AddString("rfc2136-tsig-algorithm", c.RFC2136TSIGAlgorithm)
+	enc.AddString("default-network", c.DefaultNetwork)
+	enc.AddString("resync-interval", c.ResyncInterval)
+	enc.AddString("owner-id", c.OwnerID)
+	enc.AddBool("adopt-records", c.AdoptRecords)
+	enc.AddString("provider-rate-limit", c.ProviderRateLimit)
+	enc.AddString("provider-max-retries", c.ProviderMaxRetries)
+	enc.AddString("grace-period", c.GracePeriod)
+	enc.AddString("swarm-services", c.SwarmServices)
+	enc.AddString("hostname-sources", c.HostnameSources)
+	enc.AddString("hostname-template", c.HostnameTemplate)
+	enc.AddString("allowed-domains", c.AllowedDomains)
+	enc.AddString("denied-domains", c.DeniedDomains)
 	return nil
 }
 
@@ -96,6 +164,74 @@ func (c *config) Validate() []error {
 	} else {
 		c.DataDirectory = value
 	}
+	if value, err := validateRFC2136Server(c.RFC2136Server); err != nil {
+		errs = append(errs, err)
+	} else {
+		c.RFC2136Server = value
+	}
+	if value, err := validateRFC2136TSIGAlgorithm(c.RFC2136TSIGAlgorithm); err != nil {
+		errs = append(errs, err)
+	} else {
+		c.RFC2136TSIGAlgorithm = value
+	}
+	if value, err := validateDefaultNetwork(c.DefaultNetwork); err != nil {
+		errs = append(errs, err)
+	} else {
+		c.DefaultNetwork = value
+	}
+	if value, err := validateDuration("resync-interval", c.ResyncInterval, minResyncInterval); err != nil {
+		errs = append(errs, err)
+	} else {
+		c.ResyncInterval = value
+	}
+	if value, err := validateOwnerID(c.OwnerID); err != nil {
+		errs = append(errs, err)
+	} else {
+		c.OwnerID = value
+	}
+	if c.AdoptRecords && c.OwnerID == "" {
+		errs = append(errs, errors.New("adopt-records requires an owner-id"))
+	}
+	if value, err := validateRateLimit(c.ProviderRateLimit, c.Provider); err != nil {
+		errs = append(errs, err)
+	} else {
+		c.ProviderRateLimit = value
+	}
+	if value, err := validateMaxRetries(c.ProviderMaxRetries); err != nil {
+		errs = append(errs, err)
+	} else {
+		c.ProviderMaxRetries = value
+	}
+	if value, err := validateDuration("grace-period", c.GracePeriod, minGracePeriod); err != nil {
+		errs = append(errs, err)
+	} else {
+		c.GracePeriod = value
+	}
+	if value, err := validateSwarmServices(c.SwarmServices); err != nil {
+		errs = append(errs, err)
+	} else {
+		c.SwarmServices = value
+	}
+	if value, err := validateHostnameSources(c.HostnameSources); err != nil {
+		errs = append(errs, err)
+	} else {
+		c.HostnameSources = value
+	}
+	if value, err := validateHostnameTemplate(c.HostnameTemplate); err != nil {
+		errs = append(errs, err)
+	} else {
+		c.HostnameTemplate = value
+	}
+	if value, err := validateDomains("allowed-domains", c.AllowedDomains); err != nil {
+		errs = append(errs, err)
+	} else {
+		c.AllowedDomains = value
+	}
+	if value, err := validateDomains("denied-domains", c.DeniedDomains); err != nil {
+		errs = append(errs, err)
+	} else {
+		c.DeniedDomains = value
+	}
 	return errs
 }
 
@@ -108,8 +244,10 @@ func validateProvider(provider string) (string, error) {
 		return providerCloudflare, nil
 	case providerDryrun:
 		return providerDryrun, nil
+	case providerRFC2136:
+		return providerRFC2136, nil
 	default:
-		return "", fmt.Errorf("invalid provider `%s` specified. Available providers: [`cloudflare`, `dryrun`]", provider)
+		return "", fmt.Errorf("invalid provider `%s` specified. Available providers: [`cloudflare`, `dryrun`, `rfc2136`]", provider)
 	}
 }
 
@@ -123,7 +261,7 @@ func validateAccountSecret(accountSecret string) (string, error) {
 	return accountSecret, nil
 }
 
-// validateDNSContent normalizes DNSContent and checks if it's an IPv4 or part of a list of allowable values
+// validateDNSContent normalizes DNSContent and checks if it's a comma separated list of IP addresses or part of a list of allowable values
 func validateDNSContent(dnsContent string) (string, error) {
 	dnsContent = sanitize(dnsContent)
 	switch dnsContent {
@@ -131,17 +269,19 @@ func validateDNSContent(dnsContent string) (string, error) {
 		return dnsContentContainer, nil
 	case dnsContentContainer:
 		return dnsContentContainer, nil
+	case dnsContentTailscale:
+		return dnsContentTailscale, nil
 	default:
-		ip := net.ParseIP(dnsContent)
-		if ip == nil {
-			return "", fmt.Errorf("invalid dns-content specified. `%s` must be a valid IPv4 address or one of `container`", dnsContent)
-		}
-		ip = ip.To4()
-		// TODO: remove this check when we add IPv6 support. We might want to split this config variable in 2 when we do (MODE and actual IP)
-		if ip == nil {
-			return "", fmt.Errorf("invalid dns-content specified. `%s` must be a valid IPv4 address or one of `container`", dnsContent)
+		parts := strings.Split(dnsContent, ",")
+		ips := make([]string, len(parts))
+		for i := range parts {
+			ip := net.ParseIP(strings.TrimSpace(parts[i]))
+			if ip == nil {
+				return "", fmt.Errorf("invalid dns-content specified. `%s` must be a comma separated list of IP addresses or one of `container`, `tailscale`", dnsContent)
+			}
+			ips[i] = ip.String()
 		}
-		return ip.String(), nil
+		return strings.Join(ips, ","), nil
 	}
 }
 
@@ -181,6 +321,236 @@ func validateDataDirectory(directory string) (string, error) {
 	return directory, nil
 }
 
+// validateRFC2136Server normalizes the nameserver address and adds the default DNS port if none is specified
+// An empty value is allowed, the rfc2136 provider will refuse to start without one
+func validateRFC2136Server(server string) (string, error) {
+	server = sanitize(server)
+	if server == "" {
+		return "", nil
+	}
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		// No port was specified, so the entire value is the host
+		host, port = strings.Trim(server, "[]"), "53"
+	}
+	if host == "" || port == "" {
+		return "", fmt.Errorf("invalid rfc2136-server specified. `%s` must be a hostname or IP address with an optional port", server)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// validateRFC2136TSIGAlgorithm normalizes the TSIG algorithm and checks that it is part of the list of allowable values
+func validateRFC2136TSIGAlgorithm(algorithm string) (string, error) {
+	switch value := strings.TrimSuffix(sanitize(algorithm), "."); value {
+	case "":
+		return "hmac-sha256", nil
+	case "hmac-sha1", "hmac-sha224", "hmac-sha256", "hmac-sha384", "hmac-sha512":
+		return value, nil
+	default:
+		return "", fmt.Errorf("invalid rfc2136-tsig-algorithm `%s` specified. Available algorithms: [`hmac-sha1`, `hmac-sha224`, `hmac-sha256`, `hmac-sha384`, `hmac-sha512`]", algorithm)
+	}
+}
+
+// validateDefaultNetwork trims whitespace, any other value is valid
+// Docker network names are case sensitive, so it is not lowercased
+// An empty value means that the first network of the container will be used
+//
+//nolint:unparam
+func validateDefaultNetwork(network string) (string, error) {
+	return strings.Trim(network, " \t"), nil
+}
+
+// validateDuration normalizes a duration option and checks that it is either 0 or at least the minimum
+// An empty value defaults to 0, which disables the functionality the option configures
+func validateDuration(name string, duration string, minimum time.Duration) (string, error) {
+	duration = sanitize(duration)
+	if duration == "" {
+		return "0s", nil
+	}
+	value, err := time.ParseDuration(duration)
+	if err != nil || value < 0 {
+		return "", fmt.Errorf("invalid %s `%s` specified. It must be a duration that is not negative (eg: `1h30m`)", name, duration)
+	}
+	if value != 0 && value < minimum {
+		return "", fmt.Errorf("invalid %s `%s` specified. It must be 0 or at least %s", name, duration, minimum)
+	}
+	return value.String(), nil
+}
+
+// validateOwnerID trims whitespace and checks that the owner id can be stored in an ownership TXT record
+// An empty value is allowed and disables the ownership registry
+func validateOwnerID(ownerID string) (string, error) {
+	ownerID = strings.Trim(ownerID, " \t")
+	for _, char := range ownerID {
+		if !unicode.IsLetter(char) && !unicode.IsDigit(char) && !strings.ContainsRune("-_.", char) {
+			return "", fmt.Errorf("invalid owner-id `%s` specified. It can only contain letters, digits, `-`, `_` and `.`", ownerID)
+		}
+	}
+	return ownerID, nil
+}
+
+// validateRateLimit checks that the rate limit is a number of calls per second that is not negative
+// An empty value defaults to the rate limit of the provider, 0 disables the rate limit
+func validateRateLimit(rateLimit string, provider string) (string, error) {
+	rateLimit = sanitize(rateLimit)
+	if rateLimit == "" {
+		if value, ok := defaultRateLimits[provider]; ok {
+			return value, nil
+		}
+		return "0", nil
+	}
+	value, err := strconv.ParseFloat(rateLimit, 64)
+	if err != nil || value < 0 || math.IsInf(value, 0) || math.IsNaN(value) {
+		return "", fmt.Errorf("invalid provider-rate-limit `%s` specified. It must be a number of calls per second that is not negative (eg: `4` or `0.5`)", rateLimit)
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64), nil
+}
+
+// validateMaxRetries checks that the number of retries is an integer that is not negative
+func validateMaxRetries(maxRetries string) (string, error) {
+	maxRetries = sanitize(maxRetries)
+	if maxRetries == "" {
+		return defaultMaxRetries, nil
+	}
+	value, err := strconv.Atoi(maxRetries)
+	if err != nil || value < 0 {
+		return "", fmt.Errorf("invalid provider-max-retries `%s` specified. It must be an integer that is not negative", maxRetries)
+	}
+	return strconv.Itoa(value), nil
+}
+
+// validateSwarmServices normalizes SwarmServices and checks that it is part of the list of allowable values
+// An empty value disables swarm services
+func validateSwarmServices(swarmServices string) (string, error) {
+	switch sanitize(swarmServices) {
+	case "":
+		return "", nil
+	case swarmServicesVIP:
+		return swarmServicesVIP, nil
+	case swarmServicesNodes:
+		return swarmServicesNodes, nil
+	default:
+		return "", fmt.Errorf("invalid swarm-services `%s` specified. Available values: [`vip`, `nodes`]", swarmServices)
+	}
+}
+
+// validateHostnameSources normalizes the comma separated list of HostnameSources and checks that every source
+// is part of the list of allowable values. Duplicates are removed
+func validateHostnameSources(hostnameSources string) (string, error) {
+	hostnameSources = sanitize(hostnameSources)
+	if hostnameSources == "" {
+		return hostnameSourceLabel, nil
+	}
+	sources := []string{}
+	for _, source := range strings.Split(hostnameSources, ",") {
+		switch source = strings.TrimSpace(source); source {
+		case hostnameSourceLabel, hostnameSourceTraefik, hostnameSourceCaddy:
+			if !stringslice.Contains(sources, source) {
+				sources = append(sources, source)
+			}
+		default:
+			return "", fmt.Errorf("invalid hostname-sources `%s` specified. It must be a comma separated list of: [`label`, `traefik`, `caddy`]", hostnameSources)
+		}
+	}
+	return strings.Join(sources, ","), nil
+}
+
+// validateHostnameTemplate checks that HostnameTemplate is a valid text/template that only refers to known fields
+// An empty value disables the hostname-template
+func validateHostnameTemplate(hostnameTemplate string) (string, error) {
+	hostnameTemplate = strings.TrimSpace(hostnameTemplate)
+	if hostnameTemplate == "" {
+		return "", nil
+	}
+	tmpl, err := parseHostnameTemplate(hostnameTemplate)
+	if err == nil {
+		err = tmpl.Execute(io.Discard, &hostnameTemplateData{Labels: map[string]string{}})
+	}
+	if err != nil {
+		return "", fmt.Errorf("invalid hostname-template `%s` specified: %w", hostnameTemplate, err)
+	}
+	return hostnameTemplate, nil
+}
+
+// validateDomains normalizes a comma separated list of domain patterns and checks that every pattern is valid
+// Domains are normalized like hostnames, globs are lowercased and their trailing dot is removed. Duplicates are removed
+func validateDomains(name string, domains string) (string, error) {
+	patterns := []string{}
+	for _, pattern := range strings.Split(sanitize(domains), ",") {
+		pattern = strings.TrimSuffix(strings.TrimSpace(pattern), ".")
+		if pattern == "" {
+			continue
+		}
+		var err error
+		if strings.ContainsAny(pattern, "*?[") {
+			_, err = path.Match(pattern, "")
+		} else {
+			pattern, err = types.NormalizeHostname(pattern)
+		}
+		if err != nil || strings.ContainsAny(pattern, " \t/") {
+			return "", fmt.Errorf("invalid %s `%s` specified. It must be a comma separated list of domains or globs (eg: `example.com,*.apps.example.com`)", name, domains)
+		}
+		if !stringslice.Contains(patterns, pattern) {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return strings.Join(patterns, ","), nil
+}
+
+// getRateLimit returns ProviderRateLimit as a number of calls per second
+// It should only be called after the configuration has been validated
+func (c *config) getRateLimit() float64 {
+	value, _ := strconv.ParseFloat(c.ProviderRateLimit, 64)
+	return value
+}
+
+// getMaxRetries returns ProviderMaxRetries as an int
+// It should only be called after the configuration has been validated
+func (c *config) getMaxRetries() int {
+	value, _ := strconv.Atoi(c.ProviderMaxRetries)
+	return value
+}
+
+// getHostnameSources returns HostnameSources as a slice
+// It should only be called after the configuration has been validated
+func (c *config) getHostnameSources() []string {
+	return strings.Split(c.HostnameSources, ",")
+}
+
+// getAllowedDomains returns AllowedDomains as a slice, which is empty if all domains are allowed
+// It should only be called after the configuration has been validated
+func (c *config) getAllowedDomains() []string {
+	return splitList(c.AllowedDomains)
+}
+
+// getDeniedDomains returns DeniedDomains as a slice
+// It should only be called after the configuration has been validated
+func (c *config) getDeniedDomains() []string {
+	return splitList(c.DeniedDomains)
+}
+
+// getResyncInterval returns ResyncInterval as a time.Duration
+// It should only be called after the configuration has been validated
+func (c *config) getResyncInterval() time.Duration {
+	value, _ := time.ParseDuration(c.ResyncInterval)
+	return value
+}
+
+// getGracePeriod returns GracePeriod as a time.Duration
+// It should only be called after the configuration has been validated
+func (c *config) getGracePeriod() time.Duration {
+	value, _ := time.ParseDuration(c.GracePeriod)
+	return value
+}
+
+// splitList splits a validated comma separated list, an empty list has no items
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
 func sanitize(value string) string {
 	return strings.Trim(strings.ToLower(value), " \t")
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -16,6 +17,12 @@ func TestConfigValidate(t *testing.T) {
 			assert.NotEmpty(t, input.DockerLabel, "DockerLabel should have a default value")
 			assert.NotEmpty(t, input.Store, "Store should have a default value")
 			assert.NotEmpty(t, input.DataDirectory, "DataDirectory should have a default value")
+			assert.NotEmpty(t, input.RFC2136TSIGAlgorithm, "RFC2136TSIGAlgorithm should have a default value")
+			assert.NotEmpty(t, input.ResyncInterval, "ResyncInterval should have a default value")
+			assert.NotEmpty(t, input.ProviderRateLimit, "ProviderRateLimit should have a default value")
+			assert.NotEmpty(t, input.ProviderMaxRetries, "ProviderMaxRetries should have a default value")
+			assert.NotEmpty(t, input.GracePeriod, "GracePeriod should have a default value")
+			assert.NotEmpty(t, input.HostnameSources, "HostnameSources should have a default value")
 		}
 	})
 
@@ -27,6 +34,11 @@ func TestConfigValidate(t *testing.T) {
 		errs := input.Validate()
 		assert.Equal(t, 2, len(errs), "Expected validate to receive 2 errors")
 	})
+
+	t.Run("Should require an owner id to adopt records", func(t *testing.T) {
+		input := config{AdoptRecords: true}
+		assert.Equal(t, 1, len(input.Validate()), "Expected validate to receive 1 error")
+	})
 }
 
 func TestValidateProvider(t *testing.T) {
@@ -66,6 +78,12 @@ func TestValidateProvider(t *testing.T) {
 			expected: "dryrun",
 			error:    false,
 		},
+		{
+			name:     "Should accept `rfc2136` as a valid input",
+			input:    "rfc2136",
+			expected: "rfc2136",
+			error:    false,
+		},
 		{
 			name:     "Should return an error for an invalid input",
 			input:    "my-dns-provider",
@@ -192,13 +210,42 @@ func TestValidateDNSContent(t *testing.T) {
 			expected: "192.168.0.1",
 			error:    false,
 		},
+		{
+			name:     "Should pass on an input of `tailscale`",
+			input:    "tailscale",
+			expected: "tailscale",
+			error:    false,
+		},
+		{
+			name:     "Should pass on a v6 IP address",
+			input:    "2001:db8::1",
+			expected: "2001:db8::1",
+			error:    false,
+		},
+		{
+			name:     "Should normalize a v6 IP address",
+			input:    "2001:DB8:0:0::1",
+			expected: "2001:db8::1",
+			error:    false,
+		},
+		{
+			name:     "Should pass on a list of v4 and v6 IP addresses",
+			input:    "192.168.0.1, 2001:db8::1",
+			expected: "192.168.0.1,2001:db8::1",
+			error:    false,
+		},
 		{
 			name:     "Should reject an invalid input",
 			input:    "foobar",
 			expected: "",
 			error:    true,
 		},
-		// TODO: Add an IPv6 case
+		{
+			name:     "Should reject a list with an invalid IP address",
+			input:    "192.168.0.1,foobar",
+			expected: "",
+			error:    true,
+		},
 	}
 
 	for _, tc := range cases {
@@ -353,3 +400,579 @@ func TestValidateDataDirectory(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateRFC2136Server(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+		error    bool
+	}{
+		{
+			name:     "Should allow an empty input",
+			input:    "",
+			expected: "",
+			error:    false,
+		},
+		{
+			name:     "Should pass on a host and port",
+			input:    "ns1.example.com:5353",
+			expected: "ns1.example.com:5353",
+			error:    false,
+		},
+		{
+			name:     "Should add the default port to a hostname",
+			input:    "ns1.example.com",
+			expected: "ns1.example.com:53",
+			error:    false,
+		},
+		{
+			name:     "Should add the default port to an IPv4 address",
+			input:    "192.168.0.1",
+			expected: "192.168.0.1:53",
+			error:    false,
+		},
+		{
+			name:     "Should add the default port to an IPv6 address",
+			input:    "fd00::1",
+			expected: "[fd00::1]:53",
+			error:    false,
+		},
+		{
+			name:     "Should lowercase and trim a valid input",
+			input:    "  NS1.example.com:53\t",
+			expected: "ns1.example.com:53",
+			error:    false,
+		},
+		{
+			name:     "Should reject an input without a host",
+			input:    ":53",
+			expected: "",
+			error:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := validateRFC2136Server(tc.input)
+			if tc.error {
+				assert.Errorf(t, err, "Expected `validateRFC2136Server` with input `%s` to return an error", tc.input)
+			} else {
+				assert.NoErrorf(t, err, "Expected `validateRFC2136Server` with input `%s` to not return an error", tc.input)
+			}
+			assert.Equal(t, tc.expected, output)
+		})
+	}
+}
+
+func TestValidateRFC2136TSIGAlgorithm(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+		error    bool
+	}{
+		{
+			name:     "Should set a default value of `hmac-sha256`",
+			input:    "",
+			expected: "hmac-sha256",
+			error:    false,
+		},
+		{
+			name:     "Should pass on a valid input",
+			input:    "hmac-sha512",
+			expected: "hmac-sha512",
+			error:    false,
+		},
+		{
+			name:     "Should strip the trailing dot of a valid input",
+			input:    "hmac-sha1.",
+			expected: "hmac-sha1",
+			error:    false,
+		},
+		{
+			name:     "Should lowercase and trim a valid input",
+			input:    " HMAC-SHA384\t",
+			expected: "hmac-sha384",
+			error:    false,
+		},
+		{
+			name:     "Should reject an unsupported algorithm",
+			input:    "hmac-md5",
+			expected: "",
+			error:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := validateRFC2136TSIGAlgorithm(tc.input)
+			if tc.error {
+				assert.Errorf(t, err, "Expected `validateRFC2136TSIGAlgorithm` with input `%s` to return an error", tc.input)
+			} else {
+				assert.NoErrorf(t, err, "Expected `validateRFC2136TSIGAlgorithm` with input `%s` to not return an error", tc.input)
+			}
+			assert.Equal(t, tc.expected, output)
+		})
+	}
+}
+
+func TestValidateDefaultNetwork(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+		error    bool
+	}{
+		{
+			name:     "Should allow an empty input",
+			input:    "",
+			expected: "",
+			error:    false,
+		},
+		{
+			name:     "Should trim whitespace but keep the case of a valid input",
+			input:    "  My_Network\t",
+			expected: "My_Network",
+			error:    false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := validateDefaultNetwork(tc.input)
+			if tc.error {
+				assert.Errorf(t, err, "Expected `validateDefaultNetwork` with input `%s` to return an error", tc.input)
+			} else {
+				assert.NoErrorf(t, err, "Expected `validateDefaultNetwork` with input `%s` to not return an error", tc.input)
+			}
+			assert.Equal(t, tc.expected, output)
+		})
+	}
+}
+
+func TestValidateDuration(t *testing.T) {
+	cases := []struct {
+		name         string
+		input        string
+		inputMinimum time.Duration
+		expected     string
+		error        bool
+	}{
+		{
+			name:     "Should set a default value of `0s`",
+			input:    "",
+			expected: "0s",
+			error:    false,
+		},
+		{
+			name:     "Should normalize a valid input",
+			input:    "90m",
+			expected: "1h30m0s",
+			error:    false,
+		},
+		{
+			name:     "Should lowercase and trim a valid input",
+			input:    " 5M\t",
+			expected: "5m0s",
+			error:    false,
+		},
+		{
+			name:         "Should allow 0 regardless of the minimum",
+			input:        "0",
+			inputMinimum: time.Minute,
+			expected:     "0s",
+			error:        false,
+		},
+		{
+			name:         "Should allow the minimum",
+			input:        "1m",
+			inputMinimum: time.Minute,
+			expected:     "1m0s",
+			error:        false,
+		},
+		{
+			name:         "Should reject a duration below the minimum",
+			input:        "1ms",
+			inputMinimum: time.Minute,
+			expected:     "",
+			error:        true,
+		},
+		{
+			name:     "Should reject a negative duration",
+			input:    "-5m",
+			expected: "",
+			error:    true,
+		},
+		{
+			name:     "Should reject a duration without a unit",
+			input:    "300",
+			expected: "",
+			error:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := validateDuration("test", tc.input, tc.inputMinimum)
+			if tc.error {
+				assert.Errorf(t, err, "Expected `validateDuration` with input `%s` to return an error", tc.input)
+			} else {
+				assert.NoErrorf(t, err, "Expected `validateDuration` with input `%s` to not return an error", tc.input)
+			}
+			assert.Equal(t, tc.expected, output)
+		})
+	}
+}
+
+func TestValidateOwnerID(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+		error    bool
+	}{
+		{
+			name:     "Should allow an empty value",
+			input:    "",
+			expected: "",
+			error:    false,
+		},
+		{
+			name:     "Should trim whitespace without lowercasing",
+			input:    " Docker-Host_1.lan\t",
+			expected: "Docker-Host_1.lan",
+			error:    false,
+		},
+		{
+			name:     "Should reject a value with a comma",
+			input:    "host-1,host-2",
+			expected: "",
+			error:    true,
+		},
+		{
+			name:     "Should reject a value with an equals sign",
+			input:    "owner=host-1",
+			expected: "",
+			error:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := validateOwnerID(tc.input)
+			if tc.error {
+				assert.Errorf(t, err, "Expected `validateOwnerID` with input `%s` to return an error", tc.input)
+			} else {
+				assert.NoErrorf(t, err, "Expected `validateOwnerID` with input `%s` to not return an error", tc.input)
+			}
+			assert.Equal(t, tc.expected, output)
+		})
+	}
+}
+
+func TestValidateRateLimit(t *testing.T) {
+	cases := []struct {
+		name          string
+		input         string
+		inputProvider string
+		expected      string
+		error         bool
+	}{
+		{
+			name:          "Should default to the rate limit of the provider",
+			input:         "",
+			inputProvider: "cloudflare",
+			expected:      "4",
+			error:         false,
+		},
+		{
+			name:          "Should disable the rate limit by default for an unknown provider",
+			input:         "",
+			inputProvider: "notvalid",
+			expected:      "0",
+			error:         false,
+		},
+		{
+			name:          "Should allow a fractional rate limit",
+			input:         " 0.50 ",
+			inputProvider: "cloudflare",
+			expected:      "0.5",
+			error:         false,
+		},
+		{
+			name:          "Should reject a negative rate limit",
+			input:         "-1",
+			inputProvider: "cloudflare",
+			expected:      "",
+			error:         true,
+		},
+		{
+			name:          "Should reject a value that is not a number",
+			input:         "fast",
+			inputProvider: "cloudflare",
+			expected:      "",
+			error:         true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := validateRateLimit(tc.input, tc.inputProvider)
+			if tc.error {
+				assert.Errorf(t, err, "Expected `validateRateLimit` with input `%s` to return an error", tc.input)
+			} else {
+				assert.NoErrorf(t, err, "Expected `validateRateLimit` with input `%s` to not return an error", tc.input)
+			}
+			assert.Equal(t, tc.expected, output)
+		})
+	}
+}
+
+func TestValidateMaxRetries(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+		error    bool
+	}{
+		{
+			name:     "Should set a default value",
+			input:    "",
+			expected: "5",
+			error:    false,
+		},
+		{
+			name:     "Should allow 0 to disable retries",
+			input:    "0",
+			expected: "0",
+			error:    false,
+		},
+		{
+			name:     "Should reject a negative number",
+			input:    "-1",
+			expected: "",
+			error:    true,
+		},
+		{
+			name:     "Should reject a value that is not an integer",
+			input:    "1.5",
+			expected: "",
+			error:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := validateMaxRetries(tc.input)
+			if tc.error {
+				assert.Errorf(t, err, "Expected `validateMaxRetries` with input `%s` to return an error", tc.input)
+			} else {
+				assert.NoErrorf(t, err, "Expected `validateMaxRetries` with input `%s` to not return an error", tc.input)
+			}
+			assert.Equal(t, tc.expected, output)
+		})
+	}
+}
+
+func TestValidateSwarmServices(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+		error    bool
+	}{
+		{
+			name:     "Should disable swarm services by default",
+			input:    "",
+			expected: "",
+			error:    false,
+		},
+		{
+			name:     "Should lowercase and trim a valid input",
+			input:    " VIP\t",
+			expected: "vip",
+			error:    false,
+		},
+		{
+			name:     "Should allow nodes",
+			input:    "nodes",
+			expected: "nodes",
+			error:    false,
+		},
+		{
+			name:     "Should reject an unknown value",
+			input:    "ingress",
+			expected: "",
+			error:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := validateSwarmServices(tc.input)
+			if tc.error {
+				assert.Errorf(t, err, "Expected `validateSwarmServices` with input `%s` to return an error", tc.input)
+			} else {
+				assert.NoErrorf(t, err, "Expected `validateSwarmServices` with input `%s` to not return an error", tc.input)
+			}
+			assert.Equal(t, tc.expected, output)
+		})
+	}
+}
+
+func TestValidateHostnameSources(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+		error    bool
+	}{
+		{
+			name:     "Should set a default value of `label`",
+			input:    "",
+			expected: "label",
+			error:    false,
+		},
+		{
+			name:     "Should normalize a list of sources and remove duplicates",
+			input:    " Label, TRAEFIK ,label",
+			expected: "label,traefik",
+			error:    false,
+		},
+		{
+			name:     "Should allow a single source other than label",
+			input:    "caddy",
+			expected: "caddy",
+			error:    false,
+		},
+		{
+			name:     "Should reject an unknown source",
+			input:    "label,nginx",
+			expected: "",
+			error:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := validateHostnameSources(tc.input)
+			if tc.error {
+				assert.Errorf(t, err, "Expected `validateHostnameSources` with input `%s` to return an error", tc.input)
+			} else {
+				assert.NoErrorf(t, err, "Expected `validateHostnameSources` with input `%s` to not return an error", tc.input)
+			}
+			assert.Equal(t, tc.expected, output)
+		})
+	}
+}
+
+func TestValidateHostnameTemplate(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+		error    bool
+	}{
+		{
+			name:     "Should allow an empty template",
+			input:    "  ",
+			expected: "",
+			error:    false,
+		},
+		{
+			name:     "Should trim a valid template",
+			input:    " {{.Service}}.{{.Project}}.apps.example.com ",
+			expected: "{{.Service}}.{{.Project}}.apps.example.com",
+			error:    false,
+		},
+		{
+			name:     "Should allow labels and functions",
+			input:    `{{index .Labels "app" | lower}}.example.com`,
+			expected: `{{index .Labels "app" | lower}}.example.com`,
+			error:    false,
+		},
+		{
+			name:     "Should reject a template that doesn't parse",
+			input:    "{{.Service}.example.com",
+			expected: "",
+			error:    true,
+		},
+		{
+			name:     "Should reject a template with an unknown field",
+			input:    "{{.Hostname}}.example.com",
+			expected: "",
+			error:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := validateHostnameTemplate(tc.input)
+			if tc.error {
+				assert.Errorf(t, err, "Expected `validateHostnameTemplate` with input `%s` to return an error", tc.input)
+			} else {
+				assert.NoErrorf(t, err, "Expected `validateHostnameTemplate` with input `%s` to not return an error", tc.input)
+			}
+			assert.Equal(t, tc.expected, output)
+		})
+	}
+}
+
+func TestValidateDomains(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+		error    bool
+	}{
+		{
+			name:     "Should allow an empty list",
+			input:    "",
+			expected: "",
+			error:    false,
+		},
+		{
+			name:     "Should normalize the patterns and remove duplicates",
+			input:    " Example.com., *.apps.example.com,,example.com",
+			expected: "example.com,*.apps.example.com",
+			error:    false,
+		},
+		{
+			name:     "Should reject an invalid glob",
+			input:    "example.com,[a-.example.com",
+			expected: "",
+			error:    true,
+		},
+		{
+			name:     "Should convert an internationalized domain to punycode",
+			input:    "Bücher.example.com",
+			expected: "xn--bcher-kva.example.com",
+			error:    false,
+		},
+		{
+			name:     "Should reject an invalid domain",
+			input:    "my_app.example.com",
+			expected: "",
+			error:    true,
+		},
+		{
+			name:     "Should reject a pattern with whitespace",
+			input:    "example.com,www example.com",
+			expected: "",
+			error:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := validateDomains("allowed-domains", tc.input)
+			if tc.error {
+				assert.Errorf(t, err, "Expected `validateDomains` with input `%s` to return an error", tc.input)
+			} else {
+				assert.NoErrorf(t, err, "Expected `validateDomains` with input `%s` to not return an error", tc.input)
+			}
+			assert.Equal(t, tc.expected, output)
+		})
+	}
+}
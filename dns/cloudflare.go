@@ -2,6 +2,14 @@ package dns
 
 import (
 	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	cloudflare "github.com/cloudflare/cloudflare-go"
 	"github.com/wdullaer/dd-dns/types"
@@ -9,37 +17,68 @@ import (
 )
 
 // CloudflareProvider implements the DNSProvider interface for Cloudflare
+// The ids of the zones are cached, since they never change
+// During a batch the records of every zone are listed once, and all changes are computed from that snapshot
 type CloudflareProvider struct {
-	API    *cloudflare.API
-	logger *zap.SugaredLogger
+	API      *cloudflare.API
+	zones    *zoneResolver
+	mutex    sync.Mutex
+	zoneIDs  map[string]string
+	snapshot map[string][]cloudflare.DNSRecord
+	logger   *zap.SugaredLogger
 }
 
 // NewCloudflareProvider generates a CloudflareProvider using the given credentials
+// Rate limits, server errors and network errors are returned as a TransientError, rather than retried by the
+// API client, so they can be handled by a RetryProvider
 func NewCloudflareProvider(email string, token string, logger *zap.SugaredLogger) (*CloudflareProvider, error) {
-	api, err := cloudflare.New(token, email)
+	api, err := cloudflare.New(
+		token,
+		email,
+		cloudflare.HTTPClient(&http.Client{Transport: &transientErrorTransport{transport: http.DefaultTransport}}),
+		cloudflare.UsingRetryPolicy(0, 0, 0),
+		cloudflare.UsingRateLimit(math.Inf(1)),
+	)
 	if err != nil {
 		return nil, err
 	}
-	return &CloudflareProvider{API: api, logger: logger.Named("cloudflare-dns")}, nil
+	return newCloudflareProvider(api, logger), nil
+}
+
+// newCloudflareProvider generates a CloudflareProvider using the given API client
+func newCloudflareProvider(api *cloudflare.API, logger *zap.SugaredLogger) *CloudflareProvider {
+	provider := &CloudflareProvider{API: api, zoneIDs: map[string]string{}, logger: logger.Named("cloudflare-dns")}
+	provider.zones = newZoneResolver(provider)
+	return provider
+}
+
+// StartBatch lists the records of every zone at most once until EndBatch is called
+// The snapshot is kept up to date with the changes made by this provider, but not with changes made by others
+func (provider *CloudflareProvider) StartBatch() {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	provider.snapshot = map[string][]cloudflare.DNSRecord{}
 }
 
-// AddHostnameMapping adds the given DNSMapping as an A record
-// In case an A record already exists, it will succeed, since the desired state has already been obtained
-// It will not modify any records that are not A records.
+// EndBatch discards the snapshot of the records, subsequent calls query the API again
+func (provider *CloudflareProvider) EndBatch() {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	provider.snapshot = nil
+}
+
+// AddHostnameMapping adds the given DNSMapping as an A or AAAA record (depending on the type of the mapping)
+// The TTL, proxied flag and comment of the record are taken from the settings of the mapping
+// In case the record already exists, it will succeed, since the desired state has already been obtained
+// If the settings of the existing record differ, they are updated in place
+// It will not modify any records of other types.
 func (provider *CloudflareProvider) AddHostnameMapping(mapping *types.DNSMapping) error {
 	provider.logger.Infow("Adding mapping to DNS", "mapping", mapping)
-	zoneName := getZoneName(mapping.Name)
-
-	zoneIDString, err := provider.API.ZoneIDByName(zoneName)
+	zoneID, err := provider.getZoneIdentifier(mapping.Name)
 	if err != nil {
 		return err
 	}
-	zoneID := cloudflare.ZoneIdentifier(zoneIDString)
-	records, _, err := provider.API.ListDNSRecords(
-		context.TODO(),
-		zoneID,
-		cloudflare.ListDNSRecordsParams{Type: "A", Name: mapping.Name},
-	)
+	records, err := provider.listRecords(zoneID, mapping.Type, mapping.Name)
 	if err != nil {
 		return err
 	}
@@ -49,52 +88,298 @@ func (provider *CloudflareProvider) AddHostnameMapping(mapping *types.DNSMapping
 		dnsRecord := cloudflare.CreateDNSRecordParams{
 			Name:    mapping.Name,
 			Content: mapping.IP.String(),
-			Type:    "A",
+			Type:    mapping.Type,
+			TTL:     getCloudflareTTL(mapping.Settings),
+			Proxied: cloudflare.BoolPtr(mapping.Settings.Proxied),
+			Comment: mapping.Settings.Comment,
 		}
-		if _, err = provider.API.CreateDNSRecord(
+		created, err := provider.API.CreateDNSRecord(
 			context.TODO(),
 			zoneID,
 			dnsRecord,
-		); err != nil {
+		)
+		if err != nil {
 			return err
 		}
+		provider.putSnapshotRecord(zoneID, created)
 		return nil
 	}
 
-	provider.logger.Warnw("Record already exists for DNSMapping", "dnsMapping", mapping)
+	record := records[findRecordIndex(records, mapping.IP.String())]
+	if hasSettings(record, mapping.Settings) {
+		provider.logger.Warnw("Record already exists for DNSMapping", "dnsMapping", mapping)
+		return nil
+	}
 
+	provider.logger.Infow("Updating the settings of the existing record", "dnsMapping", mapping)
+	updated, err := provider.API.UpdateDNSRecord(context.TODO(), zoneID, cloudflare.UpdateDNSRecordParams{
+		ID:      record.ID,
+		Name:    record.Name,
+		Type:    record.Type,
+		Content: record.Content,
+		TTL:     getCloudflareTTL(mapping.Settings),
+		Proxied: cloudflare.BoolPtr(mapping.Settings.Proxied),
+		Comment: cloudflare.StringPtr(mapping.Settings.Comment),
+		Tags:    record.Tags,
+	})
+	if err != nil {
+		return err
+	}
+	provider.putSnapshotRecord(zoneID, updated)
 	return nil
 }
 
-// RemoveHostnameMapping will remove the given DNSMapping from an A record
-// In case no A record or no mapping exists, the call will succeed, given that the required has already been achieved
-// It will not modify any records that are not A records
+// RemoveHostnameMapping will remove the given DNSMapping from an A or AAAA record (depending on the type of the mapping)
+// In case no record or no mapping exists, the call will succeed, given that the required has already been achieved
+// It will not modify any records of other types
 func (provider *CloudflareProvider) RemoveHostnameMapping(mapping *types.DNSMapping) error {
 	provider.logger.Infow("Removing mapping from DNS", "mapping", mapping)
-	zoneName := getZoneName(mapping.Name)
+	zoneID, err := provider.getZoneIdentifier(mapping.Name)
+	if err != nil {
+		return err
+	}
+	records, err := provider.listRecords(zoneID, mapping.Type, mapping.Name)
+	if err != nil {
+		return err
+	}
+
+	index := findRecordIndex(records, mapping.IP.String())
+	// This shouldn't happen, but it's not lethal, so log a warning and continue
+	if index == -1 {
+		provider.logger.Warnw("IP is not mapped to hostname ", "mapping", mapping)
+		return nil
+	}
+
+	return provider.deleteRecord(zoneID, records[index].ID)
+}
+
+// ListHostnameMappings returns the A and AAAA records that currently exist in Cloudflare for the given hostnames
+func (provider *CloudflareProvider) ListHostnameMappings(hostnames []string) ([]*types.DNSMapping, error) {
+	mappings := []*types.DNSMapping{}
+	for _, hostname := range hostnames {
+		zoneID, err := provider.getZoneIdentifier(hostname)
+		if err != nil {
+			return nil, err
+		}
+		records, err := provider.listRecords(zoneID, "", hostname)
+		if err != nil {
+			return nil, err
+		}
+		for i := range records {
+			if records[i].Type != types.RecordTypeA && records[i].Type != types.RecordTypeAAAA {
+				continue
+			}
+			mappings = append(mappings, &types.DNSMapping{
+				Name: hostname,
+				Type: records[i].Type,
+				IP:   net.ParseIP(records[i].Content),
+				Settings: types.RecordSettings{
+					TTL:     records[i].TTL,
+					Proxied: records[i].Proxied != nil && *records[i].Proxied,
+					Comment: records[i].Comment,
+				},
+			})
+		}
+	}
+	return mappings, nil
+}
+
+// IsManagedRecord returns true if the comment of the record shows that it was created by dd-dns
+func (provider *CloudflareProvider) IsManagedRecord(mapping *types.DNSMapping) bool {
+	return strings.HasPrefix(mapping.Settings.Comment, types.ManagedComment)
+}
+
+// HasSettings returns true if the TTL, proxy status and comment of the record match the desired settings
+func (provider *CloudflareProvider) HasSettings(mapping *types.DNSMapping, settings types.RecordSettings) bool {
+	return hasSettings(cloudflare.DNSRecord{
+		TTL:     mapping.Settings.TTL,
+		Proxied: cloudflare.BoolPtr(mapping.Settings.Proxied),
+		Comment: mapping.Settings.Comment,
+	}, settings)
+}
 
-	zoneIDString, err := provider.API.ZoneIDByName(zoneName)
+// AddTXTRecord adds a TXT record with the given value to the hostname
+// In case the record already exists, it will succeed, since the desired state has already been obtained
+func (provider *CloudflareProvider) AddTXTRecord(hostname string, value string) error {
+	provider.logger.Infow("Adding TXT record to DNS", "hostname", hostname, "value", value)
+	zoneID, err := provider.getZoneIdentifier(hostname)
 	if err != nil {
 		return err
 	}
-	zoneID := cloudflare.ZoneIdentifier(zoneIDString)
-	records, _, err := provider.API.ListDNSRecords(
+	records, err := provider.listRecords(zoneID, "TXT", hostname)
+	if err != nil {
+		return err
+	}
+	if findTXTRecordIndex(records, value) != -1 {
+		return nil
+	}
+
+	created, err := provider.API.CreateDNSRecord(
 		context.TODO(),
 		zoneID,
-		cloudflare.ListDNSRecordsParams{Name: mapping.Name, Type: "A"},
+		cloudflare.CreateDNSRecordParams{Name: hostname, Content: quoteTXT(value), Type: "TXT"},
 	)
 	if err != nil {
 		return err
 	}
+	provider.putSnapshotRecord(zoneID, created)
+	return nil
+}
 
-	index := findRecordIndex(records, mapping.IP.String())
-	// This shouldn't happen, but it's not lethal, so log a warning and continue
+// RemoveTXTRecord removes the TXT record with the given value from the hostname
+// In case no such record exists, the call will succeed, given that the required has already been achieved
+func (provider *CloudflareProvider) RemoveTXTRecord(hostname string, value string) error {
+	provider.logger.Infow("Removing TXT record from DNS", "hostname", hostname, "value", value)
+	zoneID, err := provider.getZoneIdentifier(hostname)
+	if err != nil {
+		return err
+	}
+	records, err := provider.listRecords(zoneID, "TXT", hostname)
+	if err != nil {
+		return err
+	}
+
+	index := findTXTRecordIndex(records, value)
 	if index == -1 {
-		provider.logger.Warnw("IP is not mapped to hostname ", "mapping", mapping)
+		provider.logger.Warnw("TXT record does not exist", "hostname", hostname, "value", value)
 		return nil
 	}
+	return provider.deleteRecord(zoneID, records[index].ID)
+}
+
+// ListTXTRecords returns the values of all TXT records of the hostname
+func (provider *CloudflareProvider) ListTXTRecords(hostname string) ([]string, error) {
+	zoneID, err := provider.getZoneIdentifier(hostname)
+	if err != nil {
+		return nil, err
+	}
+	records, err := provider.listRecords(zoneID, "TXT", hostname)
+	if err != nil {
+		return nil, err
+	}
 
-	return provider.API.DeleteDNSRecord(context.TODO(), zoneID, records[index].ID)
+	values := make([]string, len(records))
+	for i := range records {
+		values[i] = unquoteTXT(records[i].Content)
+	}
+	return values, nil
+}
+
+// ListZones returns the names of all zones the account has access to
+// The ids of the zones are cached as well
+func (provider *CloudflareProvider) ListZones() ([]string, error) {
+	zones, err := provider.API.ListZones(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	names := make([]string, len(zones))
+	for i := range zones {
+		names[i] = zones[i].Name
+		provider.zoneIDs[normalizeZoneName(zones[i].Name)] = zones[i].ID
+	}
+	return names, nil
+}
+
+// getZoneIdentifier looks up the Cloudflare zone that contains the hostname
+func (provider *CloudflareProvider) getZoneIdentifier(hostname string) (*cloudflare.ResourceContainer, error) {
+	zoneName, err := provider.zones.getZone(hostname)
+	if err != nil {
+		return nil, err
+	}
+
+	provider.mutex.Lock()
+	zoneIDString, ok := provider.zoneIDs[zoneName]
+	provider.mutex.Unlock()
+	if ok {
+		return cloudflare.ZoneIdentifier(zoneIDString), nil
+	}
+
+	zoneIDString, err = provider.API.ZoneIDByName(zoneName)
+	if err != nil {
+		return nil, err
+	}
+	provider.mutex.Lock()
+	provider.zoneIDs[zoneName] = zoneIDString
+	provider.mutex.Unlock()
+	return cloudflare.ZoneIdentifier(zoneIDString), nil
+}
+
+// listRecords returns the records of the hostname with the given type (or all types if it is empty)
+// During a batch, the records are taken from the snapshot of the zone, which is listed on first use
+func (provider *CloudflareProvider) listRecords(zoneID *cloudflare.ResourceContainer, recordType string, hostname string) ([]cloudflare.DNSRecord, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	if provider.snapshot == nil {
+		records, _, err := provider.API.ListDNSRecords(
+			context.TODO(),
+			zoneID,
+			cloudflare.ListDNSRecordsParams{Type: recordType, Name: hostname},
+		)
+		return records, err
+	}
+
+	zoneRecords, ok := provider.snapshot[zoneID.Identifier]
+	if !ok {
+		var err error
+		zoneRecords, _, err = provider.API.ListDNSRecords(context.TODO(), zoneID, cloudflare.ListDNSRecordsParams{})
+		if err != nil {
+			return nil, err
+		}
+		provider.snapshot[zoneID.Identifier] = zoneRecords
+	}
+	return filterRecords(zoneRecords, recordType, hostname), nil
+}
+
+// deleteRecord deletes the record with the given id and removes it from the snapshot
+func (provider *CloudflareProvider) deleteRecord(zoneID *cloudflare.ResourceContainer, recordID string) error {
+	if err := provider.API.DeleteDNSRecord(context.TODO(), zoneID, recordID); err != nil {
+		return err
+	}
+
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	zoneRecords, ok := provider.snapshot[zoneID.Identifier]
+	if !ok {
+		return nil
+	}
+	for i := range zoneRecords {
+		if zoneRecords[i].ID == recordID {
+			provider.snapshot[zoneID.Identifier] = append(zoneRecords[:i:i], zoneRecords[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// putSnapshotRecord adds a record that was created or updated to the snapshot of its zone, if there is one
+func (provider *CloudflareProvider) putSnapshotRecord(zoneID *cloudflare.ResourceContainer, record cloudflare.DNSRecord) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	zoneRecords, ok := provider.snapshot[zoneID.Identifier]
+	if !ok {
+		return
+	}
+	for i := range zoneRecords {
+		if zoneRecords[i].ID == record.ID {
+			zoneRecords[i] = record
+			return
+		}
+	}
+	provider.snapshot[zoneID.Identifier] = append(zoneRecords, record)
+}
+
+// filterRecords returns the records of the hostname with the given type (or all types if it is empty)
+func filterRecords(col []cloudflare.DNSRecord, recordType string, hostname string) []cloudflare.DNSRecord {
+	records := []cloudflare.DNSRecord{}
+	for i := range col {
+		if (recordType == "" || col[i].Type == recordType) && strings.EqualFold(col[i].Name, hostname) {
+			records = append(records, col[i])
+		}
+	}
+	return records
 }
 
 // hasRecordForIP returns true if there is at least 1 DNSRecord with the given
@@ -118,3 +403,76 @@ func findRecordIndex(col []cloudflare.DNSRecord, ip string) int {
 	}
 	return -1
 }
+
+// getCloudflareTTL returns the TTL of the record in the format of the Cloudflare API
+// Cloudflare uses 1 for an automatic TTL, which is the only value allowed for proxied records
+func getCloudflareTTL(settings types.RecordSettings) int {
+	if settings.TTL == 0 || settings.Proxied {
+		return 1
+	}
+	return settings.TTL
+}
+
+// hasSettings returns true if the settings of the DNSRecord match the desired settings
+func hasSettings(record cloudflare.DNSRecord, settings types.RecordSettings) bool {
+	proxied := record.Proxied != nil && *record.Proxied
+	return record.TTL == getCloudflareTTL(settings) && proxied == settings.Proxied && record.Comment == settings.Comment
+}
+
+// findTXTRecordIndex returns the index of the first TXT record that has the given value
+// Returns -1 if no TXT record with the given value is present in the slice
+func findTXTRecordIndex(col []cloudflare.DNSRecord, value string) int {
+	for i := range col {
+		if unquoteTXT(col[i].Content) == value {
+			return i
+		}
+	}
+	return -1
+}
+
+// quoteTXT wraps the value of a TXT record in quotes, as Cloudflare recommends
+func quoteTXT(value string) string {
+	return "\"" + value + "\""
+}
+
+// unquoteTXT removes the quotes around the value of a TXT record, if there are any
+func unquoteTXT(content string) string {
+	if len(content) >= 2 && strings.HasPrefix(content, "\"") && strings.HasSuffix(content, "\"") {
+		return content[1 : len(content)-1]
+	}
+	return content
+}
+
+// transientErrorTransport converts responses that are worth retrying into a TransientError
+// The Cloudflare API client discards the Retry-After header of a response, so it is read here
+type transientErrorTransport struct {
+	transport http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (transport *transientErrorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := transport.transport.RoundTrip(req)
+	if err != nil {
+		return nil, &TransientError{Err: err}
+	}
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < http.StatusInternalServerError {
+		return resp, nil
+	}
+	resp.Body.Close()
+	return nil, &TransientError{
+		Err:        fmt.Errorf("cloudflare API responded with HTTP %d", resp.StatusCode),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// parseRetryAfter returns the delay of a Retry-After header, which is either a number of seconds or a date
+// Returns 0 if the header is empty or invalid
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
@@ -1,12 +1,140 @@
 package dns
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	cloudflare "github.com/cloudflare/cloudflare-go"
 	"github.com/stretchr/testify/assert"
+	"github.com/wdullaer/dd-dns/types"
+	"go.uber.org/zap"
 )
 
+// fakeCloudflareAPI simulates the zones and DNS records endpoints of the Cloudflare API for a single zone
+// It counts the requests per method and path, so tests can check how many API calls were made
+type fakeCloudflareAPI struct {
+	records  []cloudflare.DNSRecord
+	requests map[string]int
+	nextID   int
+}
+
+func (api *fakeCloudflareAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.requests[r.Method+" "+r.URL.Path]++
+	var result interface{}
+	switch {
+	case r.URL.Path == "/zones":
+		result = []cloudflare.Zone{{ID: "zone-1", Name: "example.com"}}
+	case r.URL.Path == "/zones/zone-1/dns_records" && r.Method == http.MethodGet:
+		records := []cloudflare.DNSRecord{}
+		for _, record := range api.records {
+			recordType, name := r.URL.Query().Get("type"), r.URL.Query().Get("name")
+			if (recordType == "" || record.Type == recordType) && (name == "" || record.Name == name) {
+				records = append(records, record)
+			}
+		}
+		result = records
+	case r.URL.Path == "/zones/zone-1/dns_records" && r.Method == http.MethodPost:
+		record := cloudflare.DNSRecord{}
+		if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		api.nextID++
+		record.ID = "record-" + strconv.Itoa(api.nextID)
+		api.records = append(api.records, record)
+		result = record
+	case strings.HasPrefix(r.URL.Path, "/zones/zone-1/dns_records/") && r.Method == http.MethodDelete:
+		id := strings.TrimPrefix(r.URL.Path, "/zones/zone-1/dns_records/")
+		for i := range api.records {
+			if api.records[i].ID == id {
+				api.records = append(api.records[:i], api.records[i+1:]...)
+				break
+			}
+		}
+		result = map[string]string{"id": id}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"errors":      []string{},
+		"messages":    []string{},
+		"result":      result,
+		"result_info": map[string]int{"page": 1, "per_page": 100, "count": 1, "total_count": 1, "total_pages": 1},
+	})
+}
+
+// newTestCloudflareProvider returns a CloudflareProvider that talks to a fakeCloudflareAPI
+func newTestCloudflareProvider(t *testing.T, records []cloudflare.DNSRecord) (*CloudflareProvider, *fakeCloudflareAPI) {
+	fake := &fakeCloudflareAPI{records: records, requests: map[string]int{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	api, err := cloudflare.New("token", "user@example.com", cloudflare.BaseURL(server.URL), cloudflare.UsingRateLimit(1000))
+	if err != nil {
+		t.Fatalf("Failed to create cloudflare client: %s", err)
+	}
+	return newCloudflareProvider(api, zap.NewNop().Sugar()), fake
+}
+
+func TestCloudflareProviderZoneIDCache(t *testing.T) {
+	provider, fake := newTestCloudflareProvider(t, []cloudflare.DNSRecord{})
+	for _, hostname := range []string{"foo.example.com", "bar.example.com", "foo.example.com"} {
+		assert.NoError(t, provider.AddHostnameMapping(types.NewDNSMapping(hostname, net.ParseIP("192.168.0.1"), "foo")))
+	}
+	// The zones are listed once to find the zone of the hostname, which also caches its id
+	assert.Equal(t, 1, fake.requests["GET /zones"])
+	assert.Equal(t, 3, fake.requests["GET /zones/zone-1/dns_records"])
+	assert.Equal(t, 2, fake.requests["POST /zones/zone-1/dns_records"])
+}
+
+func TestCloudflareProviderBatch(t *testing.T) {
+	provider, fake := newTestCloudflareProvider(t, []cloudflare.DNSRecord{
+		{ID: "existing-1", Type: "A", Name: "old.example.com", Content: "192.168.0.1"},
+		{ID: "existing-2", Type: "A", Name: "foo.example.com", Content: "192.168.0.1", TTL: 1, Proxied: cloudflare.BoolPtr(false)},
+	})
+
+	provider.StartBatch()
+	assert.NoError(t, provider.AddHostnameMapping(types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "foo")))
+	assert.NoError(t, provider.AddHostnameMapping(types.NewDNSMapping("bar.example.com", net.ParseIP("192.168.0.1"), "bar")))
+	assert.NoError(t, provider.AddHostnameMapping(types.NewDNSMapping("bar.example.com", net.ParseIP("2001:db8::1"), "bar")))
+	assert.NoError(t, provider.RemoveHostnameMapping(types.NewDNSMapping("old.example.com", net.ParseIP("192.168.0.1"), "old")))
+
+	// The snapshot is kept up to date with the changes of the batch
+	mappings, err := provider.ListHostnameMappings([]string{"bar.example.com", "old.example.com"})
+	assert.NoError(t, err)
+	assert.Len(t, mappings, 2)
+	assert.Equal(t, 1, fake.requests["GET /zones/zone-1/dns_records"])
+	assert.Equal(t, 2, fake.requests["POST /zones/zone-1/dns_records"])
+	assert.Equal(t, 1, fake.requests["DELETE /zones/zone-1/dns_records/existing-1"])
+
+	// Outside of a batch, the records are listed again
+	provider.EndBatch()
+	mappings, err = provider.ListHostnameMappings([]string{"bar.example.com"})
+	assert.NoError(t, err)
+	assert.Len(t, mappings, 2)
+	assert.Equal(t, 2, fake.requests["GET /zones/zone-1/dns_records"])
+}
+
+func TestFilterRecords(t *testing.T) {
+	input := []cloudflare.DNSRecord{
+		{Type: "A", Name: "foo.example.com", Content: "192.168.0.1"},
+		{Type: "TXT", Name: "foo.example.com", Content: "value"},
+		{Type: "A", Name: "bar.example.com", Content: "192.168.0.2"},
+	}
+	assert.Equal(t, input[:2], filterRecords(input, "", "Foo.example.com"))
+	assert.Equal(t, input[1:2], filterRecords(input, "TXT", "foo.example.com"))
+	assert.Equal(t, []cloudflare.DNSRecord{}, filterRecords(input, "AAAA", "foo.example.com"))
+}
+
 func TestHasRecordForIP(t *testing.T) {
 	cases := []struct {
 		name       string
@@ -98,3 +226,180 @@ func TestFindIndex(t *testing.T) {
 		})
 	}
 }
+
+func TestGetCloudflareTTL(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    types.RecordSettings
+		expected int
+	}{
+		{
+			name:     "Should return automatic if no TTL is set",
+			input:    types.RecordSettings{},
+			expected: 1,
+		},
+		{
+			name:     "Should return the TTL if it is set",
+			input:    types.RecordSettings{TTL: 120},
+			expected: 120,
+		},
+		{
+			name:     "Should return automatic for a proxied record",
+			input:    types.RecordSettings{TTL: 120, Proxied: true},
+			expected: 1,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, getCloudflareTTL(tc.input))
+		})
+	}
+}
+
+func TestCloudflareHasSettings(t *testing.T) {
+	provider := &CloudflareProvider{}
+	listed := &types.DNSMapping{Settings: types.RecordSettings{TTL: 1, Comment: "managed by dd-dns"}}
+	assert.True(t, provider.HasSettings(listed, types.RecordSettings{Comment: "managed by dd-dns"}))
+	assert.False(t, provider.HasSettings(listed, types.RecordSettings{TTL: 120, Comment: "managed by dd-dns"}))
+}
+
+func TestHasSettings(t *testing.T) {
+	cases := []struct {
+		name        string
+		inputRecord cloudflare.DNSRecord
+		input       types.RecordSettings
+		expected    bool
+	}{
+		{
+			name:        "Should return true for a record with default settings",
+			inputRecord: cloudflare.DNSRecord{TTL: 1, Proxied: cloudflare.BoolPtr(false)},
+			input:       types.RecordSettings{},
+			expected:    true,
+		},
+		{
+			name:        "Should return true if all settings match",
+			inputRecord: cloudflare.DNSRecord{TTL: 1, Proxied: cloudflare.BoolPtr(true), Comment: "managed by dd-dns"},
+			input:       types.RecordSettings{Proxied: true, Comment: "managed by dd-dns"},
+			expected:    true,
+		},
+		{
+			name:        "Should return false if the TTL differs",
+			inputRecord: cloudflare.DNSRecord{TTL: 1, Proxied: cloudflare.BoolPtr(false)},
+			input:       types.RecordSettings{TTL: 120},
+			expected:    false,
+		},
+		{
+			name:        "Should return false if the proxied flag differs",
+			inputRecord: cloudflare.DNSRecord{TTL: 1},
+			input:       types.RecordSettings{Proxied: true},
+			expected:    false,
+		},
+		{
+			name:        "Should return false if the comment differs",
+			inputRecord: cloudflare.DNSRecord{TTL: 1, Comment: "edited by hand"},
+			input:       types.RecordSettings{Comment: "managed by dd-dns"},
+			expected:    false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, hasSettings(tc.inputRecord, tc.input))
+		})
+	}
+}
+
+func TestCloudflareIsManagedRecord(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    types.RecordSettings
+		expected bool
+	}{
+		{
+			name:     "Should return true for a record with the dd-dns comment",
+			input:    types.RecordSettings{Comment: "managed by dd-dns, container web"},
+			expected: true,
+		},
+		{
+			name:     "Should return false for a record without a comment",
+			input:    types.RecordSettings{},
+			expected: false,
+		},
+		{
+			name:     "Should return false for a record with another comment",
+			input:    types.RecordSettings{Comment: "added by hand, managed by dd-dns"},
+			expected: false,
+		},
+	}
+
+	provider := &CloudflareProvider{}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, provider.IsManagedRecord(&types.DNSMapping{Settings: tc.input}))
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name     string
+		input    string
+		expected time.Duration
+	}{
+		{name: "Should parse a number of seconds", input: "30", expected: 30 * time.Second},
+		{name: "Should parse a date", input: "Mon, 01 Jan 2024 12:01:00 GMT", expected: time.Minute},
+		{name: "Should ignore a date in the past", input: "Mon, 01 Jan 2024 11:00:00 GMT", expected: 0},
+		{name: "Should ignore an empty value", input: "", expected: 0},
+		{name: "Should ignore an invalid value", input: "soon", expected: 0},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, parseRetryAfter(tc.input, now))
+		})
+	}
+}
+
+func TestTransientErrorTransport(t *testing.T) {
+	cases := []struct {
+		name       string
+		input      int
+		expected   bool
+		retryAfter time.Duration
+	}{
+		{name: "Should mark a rate limit as transient", input: http.StatusTooManyRequests, expected: true, retryAfter: 10 * time.Second},
+		{name: "Should mark a server error as transient", input: http.StatusServiceUnavailable, expected: true, retryAfter: 10 * time.Second},
+		{name: "Should not mark a client error as transient", input: http.StatusForbidden, expected: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Retry-After", "10")
+				w.WriteHeader(tc.input)
+				_, _ = w.Write([]byte(`{"success":false,"errors":[{"code":1000,"message":"error"}],"messages":[],"result":null}`))
+			}))
+			defer server.Close()
+			api, err := cloudflare.New(
+				"token",
+				"user@example.com",
+				cloudflare.BaseURL(server.URL),
+				cloudflare.HTTPClient(&http.Client{Transport: &transientErrorTransport{transport: http.DefaultTransport}}),
+				cloudflare.UsingRetryPolicy(0, 0, 0),
+			)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			_, err = api.ListZones(context.Background())
+			var transientErr *TransientError
+			assert.Error(t, err)
+			assert.Equal(t, tc.expected, errors.As(err, &transientErr))
+			if tc.expected {
+				assert.Equal(t, tc.retryAfter, transientErr.RetryAfter)
+			}
+		})
+	}
+}
@@ -1,8 +1,6 @@
 package dns
 
 import (
-	"strings"
-
 	"github.com/wdullaer/dd-dns/types"
 )
 
@@ -10,13 +8,54 @@ import (
 type Provider interface {
 	AddHostnameMapping(mapping *types.DNSMapping) error
 	RemoveHostnameMapping(mapping *types.DNSMapping) error
+	// ListHostnameMappings returns the A and AAAA records that currently exist at the provider for the given hostnames
+	// The ContainerID of the returned mappings is empty
+	ListHostnameMappings(hostnames []string) ([]*types.DNSMapping, error)
+}
+
+// ManagedRecordProvider is implemented by providers that can prove that a record was created by dd-dns
+// Records at a managed hostname that are unknown to the store are only removed if the provider can prove this
+type ManagedRecordProvider interface {
+	Provider
+	// IsManagedRecord returns true if the record (as returned by ListHostnameMappings) was created by dd-dns
+	IsManagedRecord(mapping *types.DNSMapping) bool
+}
+
+// SettingsProvider is implemented by providers that report the settings of the records they list
+// Records whose settings differ from the desired settings are updated when drift is repaired
+type SettingsProvider interface {
+	Provider
+	// HasSettings returns true if the record (as returned by ListHostnameMappings) has the desired settings
+	HasSettings(mapping *types.DNSMapping, settings types.RecordSettings) bool
+}
+
+// BatchProvider is implemented by providers that can work from a snapshot of the records of a zone
+// Between StartBatch and EndBatch the records of each zone are only listed once, which saves a lot of API calls
+// when many records are changed at once (eg: during a full sync)
+type BatchProvider interface {
+	Provider
+	// StartBatch starts working from a snapshot of the records
+	StartBatch()
+	// EndBatch discards the snapshot
+	EndBatch()
+}
+
+// OwnershipProvider is implemented by providers that only modify the records they own
+// Adding a record that is owned by someone else is skipped, which is not an error
+type OwnershipProvider interface {
+	Provider
+	// AddOwnedHostnameMapping adds the record like AddHostnameMapping, it returns false if the record was skipped
+	AddOwnedHostnameMapping(mapping *types.DNSMapping) (bool, error)
 }
 
-func getZoneName(hostname string) string {
-	parts := strings.Split(hostname, ".")
-	if len(parts) < 2 {
-		return hostname
-	}
-	parts = parts[len(parts)-2:]
-	return strings.Join(parts, ".")
+// TXTProvider is implemented by providers that can also manage TXT records
+// It is required by the OwnershipRegistry to store which instance owns a record
+type TXTProvider interface {
+	Provider
+	// AddTXTRecord adds the value to the TXT records of the hostname, it succeeds if the value is already present
+	AddTXTRecord(hostname string, value string) error
+	// RemoveTXTRecord removes the value from the TXT records of the hostname, it succeeds if the value is not present
+	RemoveTXTRecord(hostname string, value string) error
+	// ListTXTRecords returns the values of all TXT records of the hostname
+	ListTXTRecords(hostname string) ([]string, error)
 }
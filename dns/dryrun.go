@@ -6,6 +6,7 @@ import (
 
 	"go.uber.org/zap"
 
+	"github.com/wdullaer/dd-dns/stringslice"
 	"github.com/wdullaer/dd-dns/types"
 )
 
@@ -13,17 +14,18 @@ import (
 // As the name suggests, it is useful in tests and to validate settings
 type DryrunProvider struct {
 	Zone   map[string][]net.IP
+	TXT    map[string][]string
 	logger *zap.SugaredLogger
 }
 
 // NewDryrunProvider generates a DryrunProvider
 func NewDryrunProvider(logger *zap.SugaredLogger) (*DryrunProvider, error) {
-	return &DryrunProvider{Zone: map[string][]net.IP{}, logger: logger.Named("dryrun-dns")}, nil
+	return &DryrunProvider{Zone: map[string][]net.IP{}, TXT: map[string][]string{}, logger: logger.Named("dryrun-dns")}, nil
 }
 
-// AddHostnameMapping adds the given DNSMapping to an A record
-// In case an A record already exists, it will append the mapping, trying to keep the current information intact
-// It will not modify any records that are not A records.
+// AddHostnameMapping adds the given DNSMapping to an A or AAAA record
+// In case the record already exists, it will append the mapping, trying to keep the current information intact
+// It will not modify any records of other types.
 func (provider *DryrunProvider) AddHostnameMapping(mapping *types.DNSMapping) error {
 	provider.logger.Infow("Adding mapping to DNS", "mapping", mapping)
 	if len(provider.Zone[mapping.Name]) == 0 {
@@ -35,9 +37,9 @@ func (provider *DryrunProvider) AddHostnameMapping(mapping *types.DNSMapping) er
 	return nil
 }
 
-// RemoveHostnameMapping will remove the given DNSMapping from an A record
-// In case no A record or no mapping exists, the call will succeed, given that the required has already been achieved
-// It will not modify any records that are not A records
+// RemoveHostnameMapping will remove the given DNSMapping from an A or AAAA record
+// In case no record or no mapping exists, the call will succeed, given that the required has already been achieved
+// It will not modify any records of other types
 func (provider *DryrunProvider) RemoveHostnameMapping(mapping *types.DNSMapping) error {
 	provider.logger.Infow("Removing mapping from DNS", "mapping", mapping)
 	record := provider.Zone[mapping.Name]
@@ -57,6 +59,43 @@ func (provider *DryrunProvider) RemoveHostnameMapping(mapping *types.DNSMapping)
 	return nil
 }
 
+// ListHostnameMappings returns the records that are currently in the in memory map for the given hostnames
+func (provider *DryrunProvider) ListHostnameMappings(hostnames []string) ([]*types.DNSMapping, error) {
+	mappings := []*types.DNSMapping{}
+	for _, hostname := range hostnames {
+		for _, ip := range provider.Zone[hostname] {
+			mappings = append(mappings, types.NewDNSMapping(hostname, ip, ""))
+		}
+	}
+	return mappings, nil
+}
+
+// AddTXTRecord adds the value to the TXT records of the hostname in the in memory map
+func (provider *DryrunProvider) AddTXTRecord(hostname string, value string) error {
+	provider.logger.Infow("Adding TXT record to DNS", "hostname", hostname, "value", value)
+	if !stringslice.Contains(provider.TXT[hostname], value) {
+		provider.TXT[hostname] = append(provider.TXT[hostname], value)
+	}
+	return nil
+}
+
+// RemoveTXTRecord removes the value from the TXT records of the hostname in the in memory map
+func (provider *DryrunProvider) RemoveTXTRecord(hostname string, value string) error {
+	provider.logger.Infow("Removing TXT record from DNS", "hostname", hostname, "value", value)
+	record := stringslice.RemoveFirst(provider.TXT[hostname], value)
+	if len(record) == 0 {
+		delete(provider.TXT, hostname)
+	} else {
+		provider.TXT[hostname] = record
+	}
+	return nil
+}
+
+// ListTXTRecords returns the TXT records of the hostname in the in memory map
+func (provider *DryrunProvider) ListTXTRecords(hostname string) ([]string, error) {
+	return append([]string{}, provider.TXT[hostname]...), nil
+}
+
 // findIPIndex returns the index of a particular IP in an IP slice.
 // Returns -1 if the IP is not present in the slice
 // Who needs generics, implementing the same function 100x is fun!
@@ -0,0 +1,228 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/wdullaer/dd-dns/types"
+	"go.uber.org/zap"
+)
+
+const (
+	ownershipHeritage  = "heritage=dd-dns"
+	ownershipOwnerKey  = "dd-dns/owner"
+	ownershipRecordKey = "dd-dns/record"
+)
+
+// OwnershipRegistry wraps a TXTProvider and ensures that only records owned by this instance are modified
+// The owner of an A or AAAA record is kept in a companion TXT record on the same hostname
+// (eg: `heritage=dd-dns,dd-dns/owner=host-1,dd-dns/record=A/192.168.0.1`)
+// This allows multiple instances of dd-dns to share a zone, or even a hostname
+type OwnershipRegistry struct {
+	OwnerID  string
+	provider TXTProvider
+	logger   *zap.SugaredLogger
+}
+
+// NewOwnershipRegistry wraps the provider in an OwnershipRegistry for the given owner
+// The provider must be able to manage TXT records
+func NewOwnershipRegistry(provider Provider, ownerID string, logger *zap.SugaredLogger) (*OwnershipRegistry, error) {
+	if ownerID == "" {
+		return nil, errors.New("no owner id specified")
+	}
+	txtProvider, ok := provider.(TXTProvider)
+	if !ok {
+		return nil, errors.New("the dns provider does not support TXT records, which are required to track ownership")
+	}
+	return &OwnershipRegistry{
+		OwnerID:  ownerID,
+		provider: txtProvider,
+		logger:   logger.Named("ownership-registry"),
+	}, nil
+}
+
+// AddHostnameMapping creates the record and its ownership record, unless a record with the same IP already exists
+// Existing records are only adopted if they are already owned by this instance (eg: after a restart)
+// Records that are owned by another instance, or that were created outside of dd-dns are not modified
+// (records without an owner can be claimed explicitly with AdoptRecords)
+func (registry *OwnershipRegistry) AddHostnameMapping(mapping *types.DNSMapping) error {
+	_, err := registry.AddOwnedHostnameMapping(mapping)
+	return err
+}
+
+// AddOwnedHostnameMapping adds the record like AddHostnameMapping
+// Returns false if the record was skipped, because it is owned by another instance or was not created by dd-dns
+func (registry *OwnershipRegistry) AddOwnedHostnameMapping(mapping *types.DNSMapping) (bool, error) {
+	owner, err := registry.getOwner(mapping)
+	if err != nil {
+		return false, err
+	}
+	if owner == registry.OwnerID {
+		return true, registry.provider.AddHostnameMapping(mapping)
+	}
+	if owner != "" {
+		registry.logger.Warnw("Record is owned by another instance, not adding it", "mapping", mapping, "owner", owner)
+		return false, nil
+	}
+
+	records, err := registry.provider.ListHostnameMappings([]string{mapping.Name})
+	if err != nil {
+		return false, err
+	}
+	if hasRecord(records, mapping) {
+		registry.logger.Warnw("Record was not created by dd-dns, not adopting it", "mapping", mapping)
+		return false, nil
+	}
+
+	if err := registry.provider.AddTXTRecord(mapping.Name, registry.getOwnershipValue(mapping)); err != nil {
+		return false, err
+	}
+	return true, registry.provider.AddHostnameMapping(mapping)
+}
+
+// RemoveHostnameMapping removes the record and its ownership record, if it is owned by this instance
+func (registry *OwnershipRegistry) RemoveHostnameMapping(mapping *types.DNSMapping) error {
+	owner, err := registry.getOwner(mapping)
+	if err != nil {
+		return err
+	}
+	if owner != registry.OwnerID {
+		registry.logger.Warnw("Record is not owned by this instance, not removing it", "mapping", mapping, "owner", owner)
+		return nil
+	}
+
+	if err := registry.provider.RemoveHostnameMapping(mapping); err != nil {
+		return err
+	}
+	return registry.provider.RemoveTXTRecord(mapping.Name, registry.getOwnershipValue(mapping))
+}
+
+// ListHostnameMappings returns the A and AAAA records of the given hostnames that are owned by this instance
+func (registry *OwnershipRegistry) ListHostnameMappings(hostnames []string) ([]*types.DNSMapping, error) {
+	records, err := registry.provider.ListHostnameMappings(hostnames)
+	if err != nil {
+		return nil, err
+	}
+
+	owned := map[string][]string{}
+	for _, hostname := range hostnames {
+		if owned[hostname], err = registry.provider.ListTXTRecords(hostname); err != nil {
+			return nil, err
+		}
+	}
+
+	mappings := []*types.DNSMapping{}
+	for _, record := range records {
+		if findOwner(owned[record.Name], record) == registry.OwnerID {
+			mappings = append(mappings, record)
+		}
+	}
+	return mappings, nil
+}
+
+// AdoptRecords claims ownership of the records of the mappings that exist at the provider, but have no owner yet
+// This allows an instance that created records before it had an owner id to take them over
+// Records that are owned by another instance are left alone
+// Returns the mappings of the records that were adopted
+func (registry *OwnershipRegistry) AdoptRecords(mappings []*types.DNSMapping) ([]*types.DNSMapping, error) {
+	adopted := []*types.DNSMapping{}
+	for _, mapping := range mappings {
+		owner, err := registry.getOwner(mapping)
+		if err != nil {
+			return adopted, err
+		}
+		if owner != "" {
+			continue
+		}
+		records, err := registry.provider.ListHostnameMappings([]string{mapping.Name})
+		if err != nil {
+			return adopted, err
+		}
+		if !hasRecord(records, mapping) {
+			continue
+		}
+		registry.logger.Infow("Adopting record", "mapping", mapping)
+		if err := registry.provider.AddTXTRecord(mapping.Name, registry.getOwnershipValue(mapping)); err != nil {
+			return adopted, err
+		}
+		adopted = append(adopted, mapping)
+	}
+	return adopted, nil
+}
+
+// IsManagedRecord returns true, since ListHostnameMappings only returns records that are owned by this instance
+func (registry *OwnershipRegistry) IsManagedRecord(*types.DNSMapping) bool {
+	return true
+}
+
+// HasSettings compares the settings of the record using the wrapped provider
+// Returns true if the wrapped provider does not report the settings of its records
+func (registry *OwnershipRegistry) HasSettings(mapping *types.DNSMapping, settings types.RecordSettings) bool {
+	if settingsProvider, ok := registry.provider.(SettingsProvider); ok {
+		return settingsProvider.HasSettings(mapping, settings)
+	}
+	return true
+}
+
+// StartBatch starts a batch in the wrapped provider, if it supports them
+func (registry *OwnershipRegistry) StartBatch() {
+	if batchProvider, ok := registry.provider.(BatchProvider); ok {
+		batchProvider.StartBatch()
+	}
+}
+
+// EndBatch ends a batch in the wrapped provider, if it supports them
+func (registry *OwnershipRegistry) EndBatch() {
+	if batchProvider, ok := registry.provider.(BatchProvider); ok {
+		batchProvider.EndBatch()
+	}
+}
+
+// getOwner returns the owner of the record of the DNSMapping
+// Returns an empty string if the record has no owner
+func (registry *OwnershipRegistry) getOwner(mapping *types.DNSMapping) (string, error) {
+	values, err := registry.provider.ListTXTRecords(mapping.Name)
+	if err != nil {
+		return "", err
+	}
+	return findOwner(values, mapping), nil
+}
+
+// getOwnershipValue returns the content of the TXT record that marks this instance as the owner of the DNSMapping
+func (registry *OwnershipRegistry) getOwnershipValue(mapping *types.DNSMapping) string {
+	return fmt.Sprintf("%s,%s=%s,%s=%s/%s", ownershipHeritage, ownershipOwnerKey, registry.OwnerID, ownershipRecordKey, mapping.Type, mapping.IP)
+}
+
+// findOwner returns the owner of the DNSMapping listed in the TXT record values
+// Returns an empty string if none of the values is an ownership record of the DNSMapping
+func findOwner(values []string, mapping *types.DNSMapping) string {
+	for _, value := range values {
+		parts := strings.Split(value, ",")
+		if parts[0] != ownershipHeritage {
+			continue
+		}
+		fields := map[string]string{}
+		for _, part := range parts[1:] {
+			if key, field, ok := strings.Cut(part, "="); ok {
+				fields[key] = field
+			}
+		}
+		recordType, ip, ok := strings.Cut(fields[ownershipRecordKey], "/")
+		if ok && recordType == mapping.Type && net.ParseIP(ip).Equal(mapping.IP) {
+			return fields[ownershipOwnerKey]
+		}
+	}
+	return ""
+}
+
+// hasRecord returns true if the DNSMapping is present in the slice, ignoring the ContainerID
+func hasRecord(col []*types.DNSMapping, mapping *types.DNSMapping) bool {
+	for i := range col {
+		if col[i].Type == mapping.Type && col[i].IP.Equal(mapping.IP) {
+			return true
+		}
+	}
+	return false
+}
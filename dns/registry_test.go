@@ -0,0 +1,175 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wdullaer/dd-dns/types"
+	"go.uber.org/zap"
+)
+
+const (
+	testOwnershipValue      = "heritage=dd-dns,dd-dns/owner=host-1,dd-dns/record=A/192.168.0.1"
+	testOtherOwnershipValue = "heritage=dd-dns,dd-dns/owner=host-2,dd-dns/record=A/192.168.0.1"
+)
+
+func newTestRegistry(t *testing.T, zone map[string][]net.IP, txt map[string][]string) (*OwnershipRegistry, *DryrunProvider) {
+	provider, err := NewDryrunProvider(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatalf("Failed to create dryrun provider: %s", err)
+	}
+	provider.Zone = zone
+	provider.TXT = txt
+	registry, err := NewOwnershipRegistry(provider, "host-1", zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatalf("Failed to create ownership registry: %s", err)
+	}
+	return registry, provider
+}
+
+func TestOwnershipRegistryAddHostnameMapping(t *testing.T) {
+	cases := []struct {
+		name         string
+		zone         map[string][]net.IP
+		txt          map[string][]string
+		expectedZone map[string][]net.IP
+		expectedTXT  map[string][]string
+		added        bool
+	}{
+		{
+			name:         "Should create the record and claim ownership of it",
+			zone:         map[string][]net.IP{},
+			txt:          map[string][]string{},
+			expectedZone: map[string][]net.IP{"foo.example.com": {net.ParseIP("192.168.0.1")}},
+			expectedTXT:  map[string][]string{"foo.example.com": {testOwnershipValue}},
+			added:        true,
+		},
+		{
+			name:         "Should re-create a record that it owns",
+			zone:         map[string][]net.IP{},
+			txt:          map[string][]string{"foo.example.com": {testOwnershipValue}},
+			expectedZone: map[string][]net.IP{"foo.example.com": {net.ParseIP("192.168.0.1")}},
+			expectedTXT:  map[string][]string{"foo.example.com": {testOwnershipValue}},
+			added:        true,
+		},
+		{
+			name:         "Should not adopt a record that was not created by dd-dns",
+			zone:         map[string][]net.IP{"foo.example.com": {net.ParseIP("192.168.0.1")}},
+			txt:          map[string][]string{},
+			expectedZone: map[string][]net.IP{"foo.example.com": {net.ParseIP("192.168.0.1")}},
+			expectedTXT:  map[string][]string{},
+		},
+		{
+			name:         "Should not add a record that is owned by another instance",
+			zone:         map[string][]net.IP{},
+			txt:          map[string][]string{"foo.example.com": {testOtherOwnershipValue}},
+			expectedZone: map[string][]net.IP{},
+			expectedTXT:  map[string][]string{"foo.example.com": {testOtherOwnershipValue}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			registry, provider := newTestRegistry(t, tc.zone, tc.txt)
+			added, err := registry.AddOwnedHostnameMapping(types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "foo"))
+			assert.NoError(t, err)
+			assert.Equal(t, tc.added, added)
+			assert.Equal(t, tc.expectedZone, provider.Zone)
+			assert.Equal(t, tc.expectedTXT, provider.TXT)
+		})
+	}
+}
+
+func TestOwnershipRegistryRemoveHostnameMapping(t *testing.T) {
+	cases := []struct {
+		name         string
+		zone         map[string][]net.IP
+		txt          map[string][]string
+		expectedZone map[string][]net.IP
+		expectedTXT  map[string][]string
+	}{
+		{
+			name:         "Should remove a record that it owns",
+			zone:         map[string][]net.IP{"foo.example.com": {net.ParseIP("192.168.0.1")}},
+			txt:          map[string][]string{"foo.example.com": {testOwnershipValue}},
+			expectedZone: map[string][]net.IP{},
+			expectedTXT:  map[string][]string{},
+		},
+		{
+			name:         "Should not remove a record that is owned by another instance",
+			zone:         map[string][]net.IP{"foo.example.com": {net.ParseIP("192.168.0.1")}},
+			txt:          map[string][]string{"foo.example.com": {testOtherOwnershipValue}},
+			expectedZone: map[string][]net.IP{"foo.example.com": {net.ParseIP("192.168.0.1")}},
+			expectedTXT:  map[string][]string{"foo.example.com": {testOtherOwnershipValue}},
+		},
+		{
+			name:         "Should not remove a record that was not created by dd-dns",
+			zone:         map[string][]net.IP{"foo.example.com": {net.ParseIP("192.168.0.1")}},
+			txt:          map[string][]string{"foo.example.com": {"v=spf1 -all"}},
+			expectedZone: map[string][]net.IP{"foo.example.com": {net.ParseIP("192.168.0.1")}},
+			expectedTXT:  map[string][]string{"foo.example.com": {"v=spf1 -all"}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			registry, provider := newTestRegistry(t, tc.zone, tc.txt)
+			assert.NoError(t, registry.RemoveHostnameMapping(types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "foo")))
+			assert.Equal(t, tc.expectedZone, provider.Zone)
+			assert.Equal(t, tc.expectedTXT, provider.TXT)
+		})
+	}
+}
+
+func TestOwnershipRegistryListHostnameMappings(t *testing.T) {
+	registry, _ := newTestRegistry(t,
+		map[string][]net.IP{"foo.example.com": {net.ParseIP("192.168.0.1"), net.ParseIP("192.168.0.2")}},
+		map[string][]string{"foo.example.com": {testOwnershipValue, "heritage=dd-dns,dd-dns/owner=host-2,dd-dns/record=A/192.168.0.2"}},
+	)
+
+	output, err := registry.ListHostnameMappings([]string{"foo.example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, []*types.DNSMapping{types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "")}, output)
+}
+
+func TestOwnershipRegistryAdoptRecords(t *testing.T) {
+	registry, provider := newTestRegistry(t,
+		map[string][]net.IP{
+			"foo.example.com": {net.ParseIP("192.168.0.1")},
+			"bar.example.com": {net.ParseIP("192.168.0.1")},
+		},
+		map[string][]string{"bar.example.com": {"heritage=dd-dns,dd-dns/owner=host-2,dd-dns/record=A/192.168.0.1"}},
+	)
+	input := []*types.DNSMapping{
+		types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "foo"),
+		types.NewDNSMapping("bar.example.com", net.ParseIP("192.168.0.1"), "bar"),
+		types.NewDNSMapping("baz.example.com", net.ParseIP("192.168.0.1"), "baz"),
+	}
+
+	output, err := registry.AdoptRecords(input)
+	assert.NoError(t, err)
+	assert.Equal(t, input[:1], output)
+	assert.Equal(t, map[string][]string{
+		"foo.example.com": {testOwnershipValue},
+		"bar.example.com": {"heritage=dd-dns,dd-dns/owner=host-2,dd-dns/record=A/192.168.0.1"},
+	}, provider.TXT)
+
+	// Adopted records can be removed on die
+	assert.NoError(t, registry.RemoveHostnameMapping(input[0]))
+	assert.Equal(t, map[string][]net.IP{"bar.example.com": {net.ParseIP("192.168.0.1")}}, provider.Zone)
+}
+
+func TestNewOwnershipRegistry(t *testing.T) {
+	provider, _ := NewDryrunProvider(zap.NewNop().Sugar())
+
+	t.Run("Should reject an empty owner id", func(t *testing.T) {
+		_, err := NewOwnershipRegistry(provider, "", zap.NewNop().Sugar())
+		assert.Error(t, err)
+	})
+
+	t.Run("Should reject a provider that does not support TXT records", func(t *testing.T) {
+		_, err := NewOwnershipRegistry(struct{ Provider }{provider}, "host-1", zap.NewNop().Sugar())
+		assert.Error(t, err)
+	})
+}
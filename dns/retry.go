@@ -0,0 +1,203 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/wdullaer/dd-dns/types"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// TransientError marks an error of a provider that is likely to go away when the call is retried
+// (eg: a rate limit, a server error or a network timeout)
+// RetryAfter is the delay the provider asked for, or 0 if it did not ask for one
+type TransientError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+// Error returns the message of the underlying error
+func (err *TransientError) Error() string {
+	return err.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (err *TransientError) Unwrap() error {
+	return err.Err
+}
+
+// RetryOptions configures the rate limit and retries of a RetryProvider
+type RetryOptions struct {
+	// RateLimit is the maximum number of calls per second, 0 disables the rate limit
+	RateLimit float64
+	// Burst is the number of calls that can be made at once before the rate limit applies
+	Burst int
+	// MaxRetries is the number of times a call that failed with a TransientError is retried
+	MaxRetries int
+	// MinBackoff is the delay before the first retry, it doubles on every subsequent retry
+	MinBackoff time.Duration
+	// MaxBackoff caps the delay between 2 retries, including the delays the provider asks for
+	MaxBackoff time.Duration
+}
+
+// RetryProvider wraps a Provider and applies a token bucket rate limit to all its calls
+// Calls that fail with a TransientError are retried with a jittered exponential backoff,
+// unless the provider asked for a specific delay (eg: in a Retry-After header)
+type RetryProvider struct {
+	provider Provider
+	options  RetryOptions
+	limiter  *rate.Limiter
+	sleep    func(time.Duration)
+	logger   *zap.SugaredLogger
+}
+
+// retryTXTProvider is a RetryProvider around a provider that also supports TXT records
+type retryTXTProvider struct {
+	*RetryProvider
+	txtProvider TXTProvider
+}
+
+// NewRetryProvider wraps the provider in a RetryProvider with the given options
+// The result supports TXT records if the wrapped provider does
+func NewRetryProvider(provider Provider, options RetryOptions, logger *zap.SugaredLogger) Provider {
+	limit := rate.Inf
+	if options.RateLimit > 0 {
+		limit = rate.Limit(options.RateLimit)
+	}
+	if options.Burst < 1 {
+		options.Burst = 1
+	}
+	retryProvider := &RetryProvider{
+		provider: provider,
+		options:  options,
+		limiter:  rate.NewLimiter(limit, options.Burst),
+		sleep:    time.Sleep,
+		logger:   logger.Named("retry-dns"),
+	}
+	if txtProvider, ok := provider.(TXTProvider); ok {
+		return &retryTXTProvider{RetryProvider: retryProvider, txtProvider: txtProvider}
+	}
+	return retryProvider
+}
+
+// AddHostnameMapping calls AddHostnameMapping of the wrapped provider
+func (provider *RetryProvider) AddHostnameMapping(mapping *types.DNSMapping) error {
+	return provider.do("AddHostnameMapping", func() error {
+		return provider.provider.AddHostnameMapping(mapping)
+	})
+}
+
+// RemoveHostnameMapping calls RemoveHostnameMapping of the wrapped provider
+func (provider *RetryProvider) RemoveHostnameMapping(mapping *types.DNSMapping) error {
+	return provider.do("RemoveHostnameMapping", func() error {
+		return provider.provider.RemoveHostnameMapping(mapping)
+	})
+}
+
+// ListHostnameMappings calls ListHostnameMappings of the wrapped provider
+func (provider *RetryProvider) ListHostnameMappings(hostnames []string) ([]*types.DNSMapping, error) {
+	var mappings []*types.DNSMapping
+	err := provider.do("ListHostnameMappings", func() error {
+		var err error
+		mappings, err = provider.provider.ListHostnameMappings(hostnames)
+		return err
+	})
+	return mappings, err
+}
+
+// IsManagedRecord forwards to the wrapped provider, it returns false if it can't prove that dd-dns created records
+func (provider *RetryProvider) IsManagedRecord(mapping *types.DNSMapping) bool {
+	if managedProvider, ok := provider.provider.(ManagedRecordProvider); ok {
+		return managedProvider.IsManagedRecord(mapping)
+	}
+	return false
+}
+
+// HasSettings forwards to the wrapped provider, it returns true if it does not report the settings of its records
+func (provider *RetryProvider) HasSettings(mapping *types.DNSMapping, settings types.RecordSettings) bool {
+	if settingsProvider, ok := provider.provider.(SettingsProvider); ok {
+		return settingsProvider.HasSettings(mapping, settings)
+	}
+	return true
+}
+
+// StartBatch starts a batch in the wrapped provider, if it supports them
+func (provider *RetryProvider) StartBatch() {
+	if batchProvider, ok := provider.provider.(BatchProvider); ok {
+		batchProvider.StartBatch()
+	}
+}
+
+// EndBatch ends a batch in the wrapped provider, if it supports them
+func (provider *RetryProvider) EndBatch() {
+	if batchProvider, ok := provider.provider.(BatchProvider); ok {
+		batchProvider.EndBatch()
+	}
+}
+
+// AddTXTRecord calls AddTXTRecord of the wrapped provider
+func (provider *retryTXTProvider) AddTXTRecord(hostname string, value string) error {
+	return provider.do("AddTXTRecord", func() error {
+		return provider.txtProvider.AddTXTRecord(hostname, value)
+	})
+}
+
+// RemoveTXTRecord calls RemoveTXTRecord of the wrapped provider
+func (provider *retryTXTProvider) RemoveTXTRecord(hostname string, value string) error {
+	return provider.do("RemoveTXTRecord", func() error {
+		return provider.txtProvider.RemoveTXTRecord(hostname, value)
+	})
+}
+
+// ListTXTRecords calls ListTXTRecords of the wrapped provider
+func (provider *retryTXTProvider) ListTXTRecords(hostname string) ([]string, error) {
+	var values []string
+	err := provider.do("ListTXTRecords", func() error {
+		var err error
+		values, err = provider.txtProvider.ListTXTRecords(hostname)
+		return err
+	})
+	return values, err
+}
+
+// do waits for the rate limit and executes the call, retrying it as long as it fails with a TransientError
+func (provider *RetryProvider) do(operation string, call func() error) error {
+	for attempt := 0; ; attempt++ {
+		if err := provider.limiter.Wait(context.Background()); err != nil {
+			return err
+		}
+		err := call()
+		var transientErr *TransientError
+		if err == nil || !errors.As(err, &transientErr) || attempt >= provider.options.MaxRetries {
+			return err
+		}
+
+		// The calls are made from the event loop, so a huge Retry-After must not block it for longer than MaxBackoff
+		delay := min(transientErr.RetryAfter, provider.options.MaxBackoff)
+		if delay <= 0 {
+			delay = getBackoff(attempt, provider.options.MinBackoff, provider.options.MaxBackoff)
+		}
+		provider.logger.Warnw("DNS provider call failed, retrying", "operation", operation, "attempt", attempt+1, "delay", delay, "err", err)
+		provider.sleep(delay)
+	}
+}
+
+// getBackoff returns the delay before the given retry attempt (starting at 0)
+// The delay doubles on every attempt, up to the maximum, and a random jitter of up to half the delay is subtracted
+// so that many failed calls don't all retry at the same time
+func getBackoff(attempt int, minimum time.Duration, maximum time.Duration) time.Duration {
+	backoff := minimum
+	for i := 0; i < attempt && backoff < maximum; i++ {
+		backoff *= 2
+	}
+	if backoff > maximum {
+		backoff = maximum
+	}
+	if backoff < 2 {
+		return backoff
+	}
+	return backoff - rand.N(backoff/2) //nolint:gosec
+}
@@ -0,0 +1,155 @@
+package dns
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wdullaer/dd-dns/types"
+	"go.uber.org/zap"
+)
+
+// failingProvider is a DryrunProvider whose AddHostnameMapping returns the given errors before it succeeds
+type failingProvider struct {
+	*DryrunProvider
+	errs  []error
+	calls int
+}
+
+func (provider *failingProvider) AddHostnameMapping(mapping *types.DNSMapping) error {
+	provider.calls++
+	if len(provider.errs) != 0 {
+		err := provider.errs[0]
+		provider.errs = provider.errs[1:]
+		return err
+	}
+	return provider.DryrunProvider.AddHostnameMapping(mapping)
+}
+
+func TestRetryProvider(t *testing.T) {
+	transientErr := &TransientError{Err: errors.New("HTTP 503")}
+	cases := []struct {
+		name           string
+		input          []error
+		expectedCalls  int
+		expectedSleeps []time.Duration
+		error          bool
+	}{
+		{
+			name:           "Should not retry a call that succeeds",
+			input:          nil,
+			expectedCalls:  1,
+			expectedSleeps: []time.Duration{},
+			error:          false,
+		},
+		{
+			name:           "Should retry transient errors with an exponential backoff",
+			input:          []error{transientErr, transientErr},
+			expectedCalls:  3,
+			expectedSleeps: []time.Duration{time.Second, 2 * time.Second},
+			error:          false,
+		},
+		{
+			name:           "Should honor the delay the provider asked for",
+			input:          []error{&TransientError{Err: errors.New("HTTP 429"), RetryAfter: 30 * time.Second}},
+			expectedCalls:  2,
+			expectedSleeps: []time.Duration{30 * time.Second},
+			error:          false,
+		},
+		{
+			name:           "Should cap the delay the provider asked for at the maximum backoff",
+			input:          []error{&TransientError{Err: errors.New("HTTP 429"), RetryAfter: 24 * time.Hour}},
+			expectedCalls:  2,
+			expectedSleeps: []time.Duration{time.Minute},
+			error:          false,
+		},
+		{
+			name:           "Should not retry other errors",
+			input:          []error{errors.New("invalid request")},
+			expectedCalls:  1,
+			expectedSleeps: []time.Duration{},
+			error:          true,
+		},
+		{
+			name:           "Should give up after the maximum number of retries",
+			input:          []error{transientErr, transientErr, transientErr, transientErr},
+			expectedCalls:  4,
+			expectedSleeps: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second},
+			error:          true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dryrun, _ := NewDryrunProvider(zap.NewNop().Sugar())
+			inner := &failingProvider{DryrunProvider: dryrun, errs: tc.input}
+			provider := NewRetryProvider(inner, RetryOptions{MaxRetries: 3, MinBackoff: time.Second, MaxBackoff: time.Minute}, zap.NewNop().Sugar()).(*retryTXTProvider)
+			sleeps := []time.Duration{}
+			provider.sleep = func(delay time.Duration) { sleeps = append(sleeps, delay) }
+
+			err := provider.AddHostnameMapping(types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "foo"))
+			if tc.error {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expectedCalls, inner.calls)
+			// The backoff is jittered by up to half of its value
+			if assert.Len(t, sleeps, len(tc.expectedSleeps)) {
+				for i := range sleeps {
+					if tc.input[i].(*TransientError).RetryAfter != 0 {
+						assert.Equal(t, tc.expectedSleeps[i], sleeps[i])
+					} else {
+						assert.InDelta(t, float64(tc.expectedSleeps[i])*0.75, float64(sleeps[i]), float64(tc.expectedSleeps[i])*0.25)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestNewRetryProvider(t *testing.T) {
+	dryrun, _ := NewDryrunProvider(zap.NewNop().Sugar())
+
+	t.Run("Should support TXT records if the wrapped provider does", func(t *testing.T) {
+		_, ok := NewRetryProvider(dryrun, RetryOptions{}, zap.NewNop().Sugar()).(TXTProvider)
+		assert.True(t, ok)
+	})
+
+	t.Run("Should not support TXT records if the wrapped provider does not", func(t *testing.T) {
+		_, ok := NewRetryProvider(struct{ Provider }{dryrun}, RetryOptions{}, zap.NewNop().Sugar()).(TXTProvider)
+		assert.False(t, ok)
+	})
+
+	t.Run("Should apply the rate limit", func(t *testing.T) {
+		provider := NewRetryProvider(dryrun, RetryOptions{RateLimit: 20}, zap.NewNop().Sugar())
+		start := time.Now()
+		for range 3 {
+			_, err := provider.ListHostnameMappings([]string{"foo.example.com"})
+			assert.NoError(t, err)
+		}
+		assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+	})
+}
+
+func TestGetBackoff(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    int
+		expected time.Duration
+	}{
+		{name: "Should start at the minimum", input: 0, expected: time.Second},
+		{name: "Should double on every attempt", input: 3, expected: 8 * time.Second},
+		{name: "Should be capped at the maximum", input: 10, expected: time.Minute},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			output := getBackoff(tc.input, time.Second, time.Minute)
+			assert.LessOrEqual(t, output, tc.expected)
+			assert.Greater(t, output, tc.expected/2)
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package dns
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	miekg "github.com/miekg/dns"
+	"github.com/wdullaer/dd-dns/types"
+	"go.uber.org/zap"
+)
+
+const (
+	rfc2136TTL        uint32 = 300
+	rfc2136TSIGFudge  uint16 = 300
+	rfc2136DNSTimeout        = 10 * time.Second
+)
+
+// RFC2136Provider implements the DNSProvider interface for authoritative nameservers
+// that accept dynamic updates as described in RFC 2136 (eg: BIND, Knot or PowerDNS)
+// Updates are signed with TSIG if a key name is configured
+type RFC2136Provider struct {
+	Server        string
+	client        *miekg.Client
+	tsigKeyName   string
+	tsigAlgorithm string
+	zones         *zoneResolver
+	logger        *zap.SugaredLogger
+}
+
+// NewRFC2136Provider generates an RFC2136Provider that sends updates to the given server (host:port)
+// If keyName is empty, the updates will not be signed
+func NewRFC2136Provider(server string, keyName string, secret string, algorithm string, logger *zap.SugaredLogger) (*RFC2136Provider, error) {
+	if server == "" {
+		return nil, errors.New("no rfc2136 server specified")
+	}
+	client := &miekg.Client{Net: "udp", Timeout: rfc2136DNSTimeout}
+	provider := &RFC2136Provider{
+		Server: server,
+		client: client,
+		logger: logger.Named("rfc2136-dns"),
+	}
+
+	provider.zones = newZoneFinderResolver(provider.findZone)
+
+	if keyName != "" {
+		if _, err := base64.StdEncoding.DecodeString(secret); err != nil {
+			return nil, fmt.Errorf("invalid tsig secret, it must be base64 encoded: %w", err)
+		}
+		provider.tsigKeyName = miekg.CanonicalName(keyName)
+		provider.tsigAlgorithm = miekg.CanonicalName(algorithm)
+		client.TsigSecret = map[string]string{provider.tsigKeyName: secret}
+	}
+	return provider, nil
+}
+
+// AddHostnameMapping adds the given DNSMapping to the RRset of the hostname
+// In case the record already exists, the server will ignore the update and the call will succeed,
+// since the desired state has already been obtained
+// The update is rejected if the hostname has a CNAME record, as it cannot hold any other data
+func (provider *RFC2136Provider) AddHostnameMapping(mapping *types.DNSMapping) error {
+	provider.logger.Infow("Adding mapping to DNS", "mapping", mapping)
+	rr, err := newResourceRecord(mapping)
+	if err != nil {
+		return err
+	}
+	return provider.insertRecord(mapping.Name, rr)
+}
+
+// RemoveHostnameMapping will remove the given DNSMapping from the RRset of the hostname
+// In case no RRset or no mapping exists, the call will succeed, given that the required has already been achieved
+// It will not modify any records of other types
+func (provider *RFC2136Provider) RemoveHostnameMapping(mapping *types.DNSMapping) error {
+	provider.logger.Infow("Removing mapping from DNS", "mapping", mapping)
+	rr, err := newResourceRecord(mapping)
+	if err != nil {
+		return err
+	}
+	return provider.removeRecord(mapping.Name, rr)
+}
+
+// ListHostnameMappings queries the server for the A and AAAA records of the given hostnames
+func (provider *RFC2136Provider) ListHostnameMappings(hostnames []string) ([]*types.DNSMapping, error) {
+	mappings := []*types.DNSMapping{}
+	for _, hostname := range hostnames {
+		for _, rrtype := range []uint16{miekg.TypeA, miekg.TypeAAAA} {
+			answer, err := provider.query(hostname, rrtype)
+			if err != nil {
+				return nil, err
+			}
+			for _, rr := range answer {
+				// The answer can also contain the records of the target of a CNAME, which belong to another hostname
+				if !strings.EqualFold(rr.Header().Name, miekg.Fqdn(hostname)) {
+					continue
+				}
+				switch record := rr.(type) {
+				case *miekg.A:
+					mappings = append(mappings, types.NewDNSMapping(hostname, record.A, ""))
+				case *miekg.AAAA:
+					mappings = append(mappings, types.NewDNSMapping(hostname, record.AAAA, ""))
+				}
+			}
+		}
+	}
+	return mappings, nil
+}
+
+// AddTXTRecord adds a TXT record with the given value to the RRset of the hostname
+func (provider *RFC2136Provider) AddTXTRecord(hostname string, value string) error {
+	provider.logger.Infow("Adding TXT record to DNS", "hostname", hostname, "value", value)
+	return provider.insertRecord(hostname, newTXTRecord(hostname, value))
+}
+
+// RemoveTXTRecord removes the TXT record with the given value from the RRset of the hostname
+func (provider *RFC2136Provider) RemoveTXTRecord(hostname string, value string) error {
+	provider.logger.Infow("Removing TXT record from DNS", "hostname", hostname, "value", value)
+	return provider.removeRecord(hostname, newTXTRecord(hostname, value))
+}
+
+// ListTXTRecords queries the server for the TXT records of the hostname
+func (provider *RFC2136Provider) ListTXTRecords(hostname string) ([]string, error) {
+	answer, err := provider.query(hostname, miekg.TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+	values := []string{}
+	for _, rr := range answer {
+		if record, ok := rr.(*miekg.TXT); ok {
+			values = append(values, strings.Join(record.Txt, ""))
+		}
+	}
+	return values, nil
+}
+
+// insertRecord sends an update that adds the resource record to the RRset of the hostname
+// The update is rejected if the hostname has a CNAME record, as it cannot hold any other data
+func (provider *RFC2136Provider) insertRecord(hostname string, rr miekg.RR) error {
+	msg, err := provider.newUpdateMessage(hostname)
+	if err != nil {
+		return err
+	}
+	msg.RRsetNotUsed([]miekg.RR{&miekg.CNAME{Hdr: miekg.RR_Header{Name: rr.Header().Name, Rrtype: miekg.TypeCNAME}}})
+	msg.Insert([]miekg.RR{rr})
+
+	response, err := provider.exchange(msg)
+	if err != nil {
+		return err
+	}
+	switch response.Rcode {
+	case miekg.RcodeSuccess:
+		return nil
+	case miekg.RcodeYXRrset:
+		return fmt.Errorf("hostname %s has a CNAME record, refusing to add %s", hostname, rr)
+	default:
+		return fmt.Errorf("dynamic update of %s failed: %s", hostname, miekg.RcodeToString[response.Rcode])
+	}
+}
+
+// removeRecord sends an update that removes the resource record from the RRset of the hostname
+func (provider *RFC2136Provider) removeRecord(hostname string, rr miekg.RR) error {
+	msg, err := provider.newUpdateMessage(hostname)
+	if err != nil {
+		return err
+	}
+	msg.RRsetUsed([]miekg.RR{rr})
+	msg.Remove([]miekg.RR{rr})
+
+	response, err := provider.exchange(msg)
+	if err != nil {
+		return err
+	}
+	switch response.Rcode {
+	case miekg.RcodeSuccess:
+		return nil
+	case miekg.RcodeNXRrset:
+		// This shouldn't happen, but it's not lethal, so log a warning and continue
+		provider.logger.Warnw("Record is not present at hostname", "hostname", hostname, "record", rr.String())
+		return nil
+	default:
+		return fmt.Errorf("dynamic update of %s failed: %s", hostname, miekg.RcodeToString[response.Rcode])
+	}
+}
+
+// query asks the server for the RRset of the given type at the hostname
+// A hostname that does not exist results in an empty answer
+func (provider *RFC2136Provider) query(hostname string, rrtype uint16) ([]miekg.RR, error) {
+	msg := &miekg.Msg{}
+	msg.SetQuestion(miekg.Fqdn(hostname), rrtype)
+	response, err := provider.exchange(msg)
+	if err != nil {
+		return nil, err
+	}
+	if response.Rcode != miekg.RcodeSuccess && response.Rcode != miekg.RcodeNameError {
+		return nil, fmt.Errorf("query of %s failed: %s", hostname, miekg.RcodeToString[response.Rcode])
+	}
+	return response.Answer, nil
+}
+
+// findZone asks the server for the SOA record of the hostname, to find the zone it belongs to
+// An authoritative server returns the SOA record in the answer for the apex of a zone,
+// and in the authority section for any other name in the zone
+// This also finds delegated subzones (eg: `lab.example.com`) that are hosted on the same server
+func (provider *RFC2136Provider) findZone(hostname string) (string, error) {
+	msg := &miekg.Msg{}
+	msg.SetQuestion(miekg.Fqdn(hostname), miekg.TypeSOA)
+	response, err := provider.exchange(msg)
+	if err != nil {
+		return "", err
+	}
+	if response.Rcode != miekg.RcodeSuccess && response.Rcode != miekg.RcodeNameError {
+		return "", fmt.Errorf("SOA query of %s failed: %s", hostname, miekg.RcodeToString[response.Rcode])
+	}
+	for _, rr := range append(response.Answer, response.Ns...) {
+		if soa, ok := rr.(*miekg.SOA); ok {
+			return soa.Hdr.Name, nil
+		}
+	}
+	return "", fmt.Errorf("the server %s is not authoritative for %s", provider.Server, hostname)
+}
+
+// exchange signs the message (if a TSIG key is configured), sends it to the server and returns the response
+func (provider *RFC2136Provider) exchange(msg *miekg.Msg) (*miekg.Msg, error) {
+	if provider.tsigKeyName != "" {
+		msg.SetTsig(provider.tsigKeyName, provider.tsigAlgorithm, rfc2136TSIGFudge, time.Now().Unix())
+	}
+	response, _, err := provider.client.Exchange(msg, provider.Server)
+	if err != nil {
+		return nil, &TransientError{Err: err}
+	}
+	if response.Rcode == miekg.RcodeServerFailure {
+		return nil, &TransientError{Err: fmt.Errorf("the server %s failed to process the request: SERVFAIL", provider.Server)}
+	}
+	return response, nil
+}
+
+// newUpdateMessage creates an empty UPDATE message for the zone of the hostname
+func (provider *RFC2136Provider) newUpdateMessage(hostname string) (*miekg.Msg, error) {
+	zone, err := provider.zones.getZone(hostname)
+	if err != nil {
+		return nil, err
+	}
+	msg := &miekg.Msg{}
+	msg.SetUpdate(miekg.Fqdn(zone))
+	return msg, nil
+}
+
+// newResourceRecord converts a DNSMapping into an A or AAAA resource record
+// The TTL of the mapping settings is used if it is set
+func newResourceRecord(mapping *types.DNSMapping) (miekg.RR, error) {
+	header := miekg.RR_Header{
+		Name:  miekg.Fqdn(mapping.Name),
+		Class: miekg.ClassINET,
+		Ttl:   rfc2136TTL,
+	}
+	if mapping.Settings.TTL > 0 {
+		header.Ttl = uint32(mapping.Settings.TTL) //nolint:gosec
+	}
+	switch mapping.Type {
+	case types.RecordTypeA:
+		ip := mapping.IP.To4()
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IPv4 address %s for hostname %s", mapping.IP, mapping.Name)
+		}
+		header.Rrtype = miekg.TypeA
+		return &miekg.A{Hdr: header, A: ip}, nil
+	case types.RecordTypeAAAA:
+		ip := mapping.IP.To16()
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IPv6 address %s for hostname %s", mapping.IP, mapping.Name)
+		}
+		header.Rrtype = miekg.TypeAAAA
+		return &miekg.AAAA{Hdr: header, AAAA: ip}, nil
+	default:
+		return nil, fmt.Errorf("unsupported record type %s for hostname %s", mapping.Type, mapping.Name)
+	}
+}
+
+// newTXTRecord creates a TXT resource record with the given value
+func newTXTRecord(hostname string, value string) miekg.RR {
+	return &miekg.TXT{
+		Hdr: miekg.RR_Header{Name: miekg.Fqdn(hostname), Rrtype: miekg.TypeTXT, Class: miekg.ClassINET, Ttl: rfc2136TTL},
+		Txt: []string{value},
+	}
+}
@@ -0,0 +1,461 @@
+package dns
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	miekg "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/wdullaer/dd-dns/types"
+	"go.uber.org/zap"
+)
+
+const (
+	testTSIGKeyName = "dd-dns."
+	testTSIGSecret  = "so6ZGir4GPAqINNh9U5c3A=="
+)
+
+// fakeNameserver is a minimal in-process stand-in for an authoritative nameserver
+// It evaluates the RRset prerequisites and applies the updates of RFC 2136 messages to an in memory zone
+// SOA records passed to it define the zones it is authoritative for (`example.com` if there are none)
+type fakeNameserver struct {
+	mutex   sync.Mutex
+	soa     []miekg.RR
+	records []miekg.RR
+	server  *miekg.Server
+}
+
+func startFakeNameserver(t *testing.T, records ...miekg.RR) (*fakeNameserver, string) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start fake nameserver: %s", err)
+	}
+
+	ns := &fakeNameserver{records: []miekg.RR{}}
+	for _, rr := range records {
+		if rr.Header().Rrtype == miekg.TypeSOA {
+			ns.soa = append(ns.soa, rr)
+		} else {
+			ns.records = append(ns.records, rr)
+		}
+	}
+	if len(ns.soa) == 0 {
+		ns.soa = []miekg.RR{mustNewRR(t, "example.com. 300 IN SOA ns.example.com. admin.example.com. 1 3600 600 86400 300")}
+	}
+	started := make(chan struct{})
+	ns.server = &miekg.Server{
+		PacketConn:        conn,
+		TsigSecret:        map[string]string{testTSIGKeyName: testTSIGSecret},
+		Handler:           miekg.HandlerFunc(ns.serveDNS),
+		NotifyStartedFunc: func() { close(started) },
+		// The default accept func rejects dynamic updates
+		MsgAcceptFunc: func(miekg.Header) miekg.MsgAcceptAction { return miekg.MsgAccept },
+	}
+	go ns.server.ActivateAndServe() //nolint:errcheck
+	<-started
+	t.Cleanup(func() { ns.server.Shutdown() }) //nolint:errcheck
+
+	return ns, conn.LocalAddr().String()
+}
+
+func (ns *fakeNameserver) serveDNS(w miekg.ResponseWriter, req *miekg.Msg) {
+	ns.mutex.Lock()
+	defer ns.mutex.Unlock()
+
+	resp := &miekg.Msg{}
+	resp.SetReply(req)
+	if tsig := req.IsTsig(); tsig != nil {
+		if w.TsigStatus() != nil {
+			resp.Rcode = miekg.RcodeNotAuth
+		} else {
+			resp.SetTsig(tsig.Hdr.Name, tsig.Algorithm, tsig.Fudge, int64(tsig.TimeSigned)) //nolint:gosec
+		}
+	} else {
+		resp.Rcode = miekg.RcodeRefused
+	}
+	if resp.Rcode == miekg.RcodeSuccess {
+		if req.Opcode == miekg.OpcodeQuery {
+			resp.Answer, resp.Ns = ns.query(req.Question[0])
+		} else {
+			resp.Rcode = ns.update(req)
+		}
+	}
+	w.WriteMsg(resp) //nolint:errcheck
+}
+
+func (ns *fakeNameserver) query(question miekg.Question) ([]miekg.RR, []miekg.RR) {
+	soa := ns.findSOA(question.Name)
+	if soa == nil {
+		return nil, nil
+	}
+	if question.Qtype == miekg.TypeSOA && soa.Header().Name == question.Name {
+		return []miekg.RR{soa}, nil
+	}
+	answer := []miekg.RR{}
+	for _, rr := range ns.records {
+		if rr.Header().Name == question.Name && rr.Header().Rrtype == question.Qtype {
+			answer = append(answer, rr)
+		}
+	}
+	// Follow a CNAME, like a real nameserver, so the answer also contains the records of its target
+	for _, rr := range ns.records {
+		if cname, ok := rr.(*miekg.CNAME); ok && len(answer) == 0 && cname.Hdr.Name == question.Name {
+			target, _ := ns.query(miekg.Question{Name: cname.Target, Qtype: question.Qtype, Qclass: question.Qclass})
+			answer = append([]miekg.RR{cname}, target...)
+		}
+	}
+	if len(answer) == 0 {
+		return answer, []miekg.RR{soa}
+	}
+	return answer, nil
+}
+
+// findSOA returns the SOA record of the most specific zone that contains the name
+func (ns *fakeNameserver) findSOA(name string) miekg.RR {
+	var match miekg.RR
+	for _, soa := range ns.soa {
+		if miekg.IsSubDomain(soa.Header().Name, name) && (match == nil || len(soa.Header().Name) > len(match.Header().Name)) {
+			match = soa
+		}
+	}
+	return match
+}
+
+func (ns *fakeNameserver) update(req *miekg.Msg) int {
+	if soa := ns.findSOA(req.Question[0].Name); soa == nil || soa.Header().Name != req.Question[0].Name {
+		return miekg.RcodeNotAuth
+	}
+	for _, rr := range append(req.Answer, req.Ns...) {
+		if soa := ns.findSOA(rr.Header().Name); soa == nil || soa.Header().Name != req.Question[0].Name {
+			return miekg.RcodeNotZone
+		}
+	}
+	for _, prereq := range req.Answer {
+		hdr := prereq.Header()
+		switch {
+		case hdr.Class == miekg.ClassANY && !ns.hasRRset(hdr.Name, hdr.Rrtype):
+			return miekg.RcodeNXRrset
+		case hdr.Class == miekg.ClassNONE && ns.hasRRset(hdr.Name, hdr.Rrtype):
+			return miekg.RcodeYXRrset
+		}
+	}
+	for _, rr := range req.Ns {
+		switch rr.Header().Class {
+		case miekg.ClassINET:
+			if ns.findRecordIndex(rr) == -1 {
+				ns.records = append(ns.records, rr)
+			}
+		case miekg.ClassNONE:
+			rr = miekg.Copy(rr)
+			rr.Header().Class = miekg.ClassINET
+			if index := ns.findRecordIndex(rr); index != -1 {
+				ns.records = append(ns.records[:index], ns.records[index+1:]...)
+			}
+		}
+	}
+	return miekg.RcodeSuccess
+}
+
+func (ns *fakeNameserver) hasRRset(name string, rrtype uint16) bool {
+	for _, rr := range ns.records {
+		if rr.Header().Name == name && rr.Header().Rrtype == rrtype {
+			return true
+		}
+	}
+	return false
+}
+
+func (ns *fakeNameserver) findRecordIndex(item miekg.RR) int {
+	for i, rr := range ns.records {
+		if miekg.IsDuplicate(rr, item) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (ns *fakeNameserver) getRecords() []string {
+	ns.mutex.Lock()
+	defer ns.mutex.Unlock()
+	output := make([]string, len(ns.records))
+	for i, rr := range ns.records {
+		output[i] = rr.String()
+	}
+	return output
+}
+
+func mustNewRR(t *testing.T, s string) miekg.RR {
+	rr, err := miekg.NewRR(s)
+	if err != nil {
+		t.Fatalf("Failed to parse resource record `%s`: %s", s, err)
+	}
+	return rr
+}
+
+func TestRFC2136ProviderAddHostnameMapping(t *testing.T) {
+	cases := []struct {
+		name     string
+		records  []string
+		input    types.DNSMapping
+		expected []string
+		error    bool
+	}{
+		{
+			name:     "Should create a record for a new hostname",
+			input:    types.DNSMapping{Name: "foo.example.com", Type: types.RecordTypeA, IP: net.ParseIP("192.168.0.1")},
+			expected: []string{"foo.example.com.\t300\tIN\tA\t192.168.0.1"},
+		},
+		{
+			name:    "Should append a record to an existing RRset",
+			records: []string{"foo.example.com. 300 IN A 192.168.0.2"},
+			input:   types.DNSMapping{Name: "foo.example.com", Type: types.RecordTypeA, IP: net.ParseIP("192.168.0.1")},
+			expected: []string{
+				"foo.example.com.\t300\tIN\tA\t192.168.0.2",
+				"foo.example.com.\t300\tIN\tA\t192.168.0.1",
+			},
+		},
+		{
+			name:     "Should succeed if the record already exists",
+			records:  []string{"foo.example.com. 300 IN A 192.168.0.1"},
+			input:    types.DNSMapping{Name: "foo.example.com", Type: types.RecordTypeA, IP: net.ParseIP("192.168.0.1")},
+			expected: []string{"foo.example.com.\t300\tIN\tA\t192.168.0.1"},
+		},
+		{
+			name:     "Should use the TTL of the mapping settings",
+			input:    types.DNSMapping{Name: "foo.example.com", Type: types.RecordTypeA, IP: net.ParseIP("192.168.0.1"), Settings: types.RecordSettings{TTL: 120}},
+			expected: []string{"foo.example.com.\t120\tIN\tA\t192.168.0.1"},
+		},
+		{
+			name:    "Should create an AAAA record for an IPv6 mapping",
+			records: []string{"foo.example.com. 300 IN A 192.168.0.1"},
+			input:   types.DNSMapping{Name: "foo.example.com", Type: types.RecordTypeAAAA, IP: net.ParseIP("2001:db8::1")},
+			expected: []string{
+				"foo.example.com.\t300\tIN\tA\t192.168.0.1",
+				"foo.example.com.\t300\tIN\tAAAA\t2001:db8::1",
+			},
+		},
+		{
+			name:     "Should return an error if the hostname has a CNAME record",
+			records:  []string{"foo.example.com. 300 IN CNAME bar.example.com."},
+			input:    types.DNSMapping{Name: "foo.example.com", Type: types.RecordTypeA, IP: net.ParseIP("192.168.0.1")},
+			expected: []string{"foo.example.com.\t300\tIN\tCNAME\tbar.example.com."},
+			error:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			records := make([]miekg.RR, len(tc.records))
+			for i := range tc.records {
+				records[i] = mustNewRR(t, tc.records[i])
+			}
+			ns, addr := startFakeNameserver(t, records...)
+			provider, err := NewRFC2136Provider(addr, testTSIGKeyName, testTSIGSecret, miekg.HmacSHA256, zap.NewNop().Sugar())
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			err = provider.AddHostnameMapping(&tc.input)
+			if tc.error {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expected, ns.getRecords())
+		})
+	}
+}
+
+func TestRFC2136ProviderRemoveHostnameMapping(t *testing.T) {
+	cases := []struct {
+		name     string
+		records  []string
+		input    types.DNSMapping
+		expected []string
+	}{
+		{
+			name: "Should remove the record from the RRset",
+			records: []string{
+				"foo.example.com. 300 IN A 192.168.0.1",
+				"foo.example.com. 300 IN A 192.168.0.2",
+			},
+			input:    types.DNSMapping{Name: "foo.example.com", Type: types.RecordTypeA, IP: net.ParseIP("192.168.0.1")},
+			expected: []string{"foo.example.com.\t300\tIN\tA\t192.168.0.2"},
+		},
+		{
+			name: "Should only remove the AAAA record of an IPv6 mapping",
+			records: []string{
+				"foo.example.com. 300 IN A 192.168.0.1",
+				"foo.example.com. 300 IN AAAA 2001:db8::1",
+			},
+			input:    types.DNSMapping{Name: "foo.example.com", Type: types.RecordTypeAAAA, IP: net.ParseIP("2001:db8::1")},
+			expected: []string{"foo.example.com.\t300\tIN\tA\t192.168.0.1"},
+		},
+		{
+			name:     "Should succeed if the RRset does not exist",
+			records:  []string{"bar.example.com. 300 IN A 192.168.0.1"},
+			input:    types.DNSMapping{Name: "foo.example.com", Type: types.RecordTypeA, IP: net.ParseIP("192.168.0.1")},
+			expected: []string{"bar.example.com.\t300\tIN\tA\t192.168.0.1"},
+		},
+		{
+			name:     "Should not modify records of other types",
+			records:  []string{"foo.example.com. 300 IN TXT \"192.168.0.1\""},
+			input:    types.DNSMapping{Name: "foo.example.com", Type: types.RecordTypeA, IP: net.ParseIP("192.168.0.1")},
+			expected: []string{"foo.example.com.\t300\tIN\tTXT\t\"192.168.0.1\""},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			records := make([]miekg.RR, len(tc.records))
+			for i := range tc.records {
+				records[i] = mustNewRR(t, tc.records[i])
+			}
+			ns, addr := startFakeNameserver(t, records...)
+			provider, err := NewRFC2136Provider(addr, testTSIGKeyName, testTSIGSecret, miekg.HmacSHA256, zap.NewNop().Sugar())
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			assert.NoError(t, provider.RemoveHostnameMapping(&tc.input))
+			assert.Equal(t, tc.expected, ns.getRecords())
+		})
+	}
+}
+
+func TestRFC2136ProviderListHostnameMappings(t *testing.T) {
+	_, addr := startFakeNameserver(t,
+		mustNewRR(t, "foo.example.com. 300 IN A 192.168.0.1"),
+		mustNewRR(t, "foo.example.com. 300 IN AAAA 2001:db8::1"),
+		mustNewRR(t, "foo.example.com. 300 IN TXT \"192.168.0.2\""),
+		mustNewRR(t, "bar.example.com. 300 IN A 192.168.0.3"),
+		mustNewRR(t, "baz.example.com. 300 IN A 192.168.0.4"),
+		mustNewRR(t, "qux.example.com. 300 IN CNAME baz.example.com."),
+	)
+	provider, err := NewRFC2136Provider(addr, testTSIGKeyName, testTSIGSecret, miekg.HmacSHA256, zap.NewNop().Sugar())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	output, err := provider.ListHostnameMappings([]string{"foo.example.com", "bar.example.com", "qux.example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, []*types.DNSMapping{
+		{Name: "foo.example.com", Type: types.RecordTypeA, IP: net.ParseIP("192.168.0.1").To4()},
+		{Name: "foo.example.com", Type: types.RecordTypeAAAA, IP: net.ParseIP("2001:db8::1")},
+		{Name: "bar.example.com", Type: types.RecordTypeA, IP: net.ParseIP("192.168.0.3").To4()},
+	}, output)
+}
+
+func TestRFC2136ProviderTXTRecords(t *testing.T) {
+	ns, addr := startFakeNameserver(t, mustNewRR(t, "foo.example.com. 300 IN A 192.168.0.1"))
+	provider, err := NewRFC2136Provider(addr, testTSIGKeyName, testTSIGSecret, miekg.HmacSHA256, zap.NewNop().Sugar())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	t.Run("Should add a TXT record", func(t *testing.T) {
+		assert.NoError(t, provider.AddTXTRecord("foo.example.com", "heritage=dd-dns"))
+		assert.Equal(t, []string{
+			"foo.example.com.\t300\tIN\tA\t192.168.0.1",
+			"foo.example.com.\t300\tIN\tTXT\t\"heritage=dd-dns\"",
+		}, ns.getRecords())
+	})
+
+	t.Run("Should list the TXT records", func(t *testing.T) {
+		output, err := provider.ListTXTRecords("foo.example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"heritage=dd-dns"}, output)
+	})
+
+	t.Run("Should remove a TXT record", func(t *testing.T) {
+		assert.NoError(t, provider.RemoveTXTRecord("foo.example.com", "heritage=dd-dns"))
+		assert.Equal(t, []string{"foo.example.com.\t300\tIN\tA\t192.168.0.1"}, ns.getRecords())
+	})
+}
+
+func TestRFC2136ProviderZone(t *testing.T) {
+	ns, addr := startFakeNameserver(t,
+		mustNewRR(t, "example.com. 300 IN SOA ns.example.com. admin.example.com. 1 3600 600 86400 300"),
+		mustNewRR(t, "lab.example.com. 300 IN SOA ns.example.com. admin.example.com. 1 3600 600 86400 300"),
+	)
+	provider, err := NewRFC2136Provider(addr, testTSIGKeyName, testTSIGSecret, miekg.HmacSHA256, zap.NewNop().Sugar())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+		error    bool
+	}{
+		{
+			name:     "Should find the zone of a hostname",
+			input:    "foo.example.com",
+			expected: "example.com",
+		},
+		{
+			name:     "Should find the zone of the apex of a zone",
+			input:    "example.com",
+			expected: "example.com",
+		},
+		{
+			name:     "Should find a delegated subzone",
+			input:    "foo.lab.example.com",
+			expected: "lab.example.com",
+		},
+		{
+			name:  "Should return an error if the server is not authoritative",
+			input: "foo.example.org",
+			error: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := provider.zones.getZone(tc.input)
+			if tc.error {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expected, output)
+		})
+	}
+
+	t.Run("Should send the update to a delegated subzone", func(t *testing.T) {
+		mapping := &types.DNSMapping{Name: "foo.lab.example.com", Type: types.RecordTypeA, IP: net.ParseIP("192.168.0.1")}
+		assert.NoError(t, provider.AddHostnameMapping(mapping))
+		assert.Equal(t, []string{"foo.lab.example.com.\t300\tIN\tA\t192.168.0.1"}, ns.getRecords())
+	})
+}
+
+func TestRFC2136ProviderTSIG(t *testing.T) {
+	_, addr := startFakeNameserver(t)
+	mapping := &types.DNSMapping{Name: "foo.example.com", Type: types.RecordTypeA, IP: net.ParseIP("192.168.0.1")}
+
+	t.Run("Should return an error if the server rejects the signature", func(t *testing.T) {
+		provider, err := NewRFC2136Provider(addr, testTSIGKeyName, "c2VjcmV0", miekg.HmacSHA256, zap.NewNop().Sugar())
+		if assert.NoError(t, err) {
+			assert.Error(t, provider.AddHostnameMapping(mapping))
+		}
+	})
+
+	t.Run("Should return an error if the update is not signed", func(t *testing.T) {
+		provider, err := NewRFC2136Provider(addr, "", "", "", zap.NewNop().Sugar())
+		if assert.NoError(t, err) {
+			assert.Error(t, provider.AddHostnameMapping(mapping))
+		}
+	})
+
+	t.Run("Should reject a secret that is not base64 encoded", func(t *testing.T) {
+		_, err := NewRFC2136Provider(addr, testTSIGKeyName, "not base64!", miekg.HmacSHA256, zap.NewNop().Sugar())
+		assert.Error(t, err)
+	})
+
+	t.Run("Should reject an empty server", func(t *testing.T) {
+		_, err := NewRFC2136Provider("", testTSIGKeyName, testTSIGSecret, miekg.HmacSHA256, zap.NewNop().Sugar())
+		assert.Error(t, err)
+	})
+}
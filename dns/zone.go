@@ -0,0 +1,110 @@
+package dns
+
+import (
+	"strings"
+	"sync"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// ZoneLister is implemented by providers that can list the zones the account owns
+// It allows hostnames in delegated subzones (eg: `lab.example.com`) to be resolved to the right zone
+type ZoneLister interface {
+	ListZones() ([]string, error)
+}
+
+// zoneFinder asks the provider for the zone of a single hostname (eg: with an SOA query)
+type zoneFinder func(hostname string) (string, error)
+
+// zoneResolver finds the zone a hostname belongs to and caches the result
+// If a zoneFinder is available, the provider decides the zone of every hostname
+// If a ZoneLister is available, the longest zone of the account that contains the hostname wins
+// Otherwise (or if no zone of the account matches) the public suffix list is used
+type zoneResolver struct {
+	lister ZoneLister
+	finder zoneFinder
+	mutex  sync.Mutex
+	zones  []string
+	cache  map[string]string
+}
+
+// newZoneResolver creates a zoneResolver that consults the zones of the account, the lister can be nil
+func newZoneResolver(lister ZoneLister) *zoneResolver {
+	return &zoneResolver{lister: lister, cache: map[string]string{}}
+}
+
+// newZoneFinderResolver creates a zoneResolver that asks the provider for the zone of every hostname
+func newZoneFinderResolver(finder zoneFinder) *zoneResolver {
+	return &zoneResolver{finder: finder, cache: map[string]string{}}
+}
+
+// getZone returns the name of the zone that contains the hostname
+func (resolver *zoneResolver) getZone(hostname string) (string, error) {
+	resolver.mutex.Lock()
+	defer resolver.mutex.Unlock()
+
+	hostname = normalizeZoneName(hostname)
+	if zone, ok := resolver.cache[hostname]; ok {
+		return zone, nil
+	}
+
+	if resolver.finder != nil {
+		zone, err := resolver.finder(hostname)
+		if err != nil {
+			return "", err
+		}
+		zone = normalizeZoneName(zone)
+		resolver.cache[hostname] = zone
+		return zone, nil
+	}
+
+	if resolver.lister != nil {
+		if resolver.zones == nil {
+			zones, err := resolver.lister.ListZones()
+			if err != nil {
+				return "", err
+			}
+			resolver.zones = zones
+		}
+		if zone := findLongestZone(resolver.zones, hostname); zone != "" {
+			resolver.cache[hostname] = zone
+			return zone, nil
+		}
+		// The zone might have been added after we listed them, so refresh the list the next time
+		resolver.zones = nil
+		return getZoneName(hostname), nil
+	}
+
+	zone := getZoneName(hostname)
+	resolver.cache[hostname] = zone
+	return zone, nil
+}
+
+// getZoneName returns the registrable domain of the hostname according to the public suffix list
+// (eg: `example.co.uk` for `app.example.co.uk`)
+// If the hostname has no registrable domain (eg: it is a public suffix itself), the hostname is returned
+func getZoneName(hostname string) string {
+	zone, err := publicsuffix.EffectiveTLDPlusOne(hostname)
+	if err != nil {
+		return hostname
+	}
+	return zone
+}
+
+// findLongestZone returns the longest zone that is equal to or a parent of the hostname
+// Returns an empty string if none of the zones contains the hostname
+func findLongestZone(zones []string, hostname string) string {
+	match := ""
+	for _, zone := range zones {
+		zone = normalizeZoneName(zone)
+		if (hostname == zone || strings.HasSuffix(hostname, "."+zone)) && len(zone) > len(match) {
+			match = zone
+		}
+	}
+	return match
+}
+
+// normalizeZoneName lowercases a domain name and removes the trailing dot
+func normalizeZoneName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
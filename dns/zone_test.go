@@ -0,0 +1,142 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeZoneLister returns a fixed list of zones and counts how often it is called
+type fakeZoneLister struct {
+	zones []string
+	calls int
+}
+
+func (lister *fakeZoneLister) ListZones() ([]string, error) {
+	lister.calls++
+	return lister.zones, nil
+}
+
+func TestGetZoneNamePublicSuffix(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "Should use the public suffix list for multi label suffixes",
+			input:    "app.example.co.uk",
+			expected: "example.co.uk",
+		},
+		{
+			name:     "Should return the input if it is a public suffix",
+			input:    "co.uk",
+			expected: "co.uk",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, getZoneName(tc.input))
+		})
+	}
+}
+
+func TestFindLongestZone(t *testing.T) {
+	cases := []struct {
+		name       string
+		inputZones []string
+		input      string
+		expected   string
+	}{
+		{
+			name:       "Should prefer the longest matching zone",
+			inputZones: []string{"example.com", "lab.example.com"},
+			input:      "foo.lab.example.com",
+			expected:   "lab.example.com",
+		},
+		{
+			name:       "Should match the apex of a zone",
+			inputZones: []string{"example.com", "lab.example.com"},
+			input:      "lab.example.com",
+			expected:   "lab.example.com",
+		},
+		{
+			name:       "Should not match a zone that is only a string suffix",
+			inputZones: []string{"ample.com"},
+			input:      "foo.example.com",
+			expected:   "",
+		},
+		{
+			name:       "Should ignore case and trailing dots of zones",
+			inputZones: []string{"Example.COM."},
+			input:      "foo.example.com",
+			expected:   "example.com",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, findLongestZone(tc.inputZones, tc.input))
+		})
+	}
+}
+
+func TestZoneResolver(t *testing.T) {
+	t.Run("Should prefer a zone of the account over the public suffix list", func(t *testing.T) {
+		resolver := newZoneResolver(&fakeZoneLister{zones: []string{"example.com", "lab.example.com"}})
+		output, err := resolver.getZone("foo.lab.example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "lab.example.com", output)
+	})
+
+	t.Run("Should use the public suffix list without a lister", func(t *testing.T) {
+		resolver := newZoneResolver(nil)
+		output, err := resolver.getZone("app.example.co.uk")
+		assert.NoError(t, err)
+		assert.Equal(t, "example.co.uk", output)
+	})
+
+	t.Run("Should cache the zones of the account", func(t *testing.T) {
+		lister := &fakeZoneLister{zones: []string{"example.com"}}
+		resolver := newZoneResolver(lister)
+		for _, hostname := range []string{"foo.example.com", "bar.example.com", "foo.example.com"} {
+			output, err := resolver.getZone(hostname)
+			assert.NoError(t, err)
+			assert.Equal(t, "example.com", output)
+		}
+		assert.Equal(t, 1, lister.calls)
+	})
+
+	t.Run("Should list the zones again after a miss", func(t *testing.T) {
+		lister := &fakeZoneLister{zones: []string{"example.com"}}
+		resolver := newZoneResolver(lister)
+		output, err := resolver.getZone("foo.example.org")
+		assert.NoError(t, err)
+		assert.Equal(t, "example.org", output)
+
+		lister.zones = []string{"example.com", "example.org"}
+		output, err = resolver.getZone("foo.example.org")
+		assert.NoError(t, err)
+		assert.Equal(t, "example.org", output)
+		assert.Equal(t, 2, lister.calls)
+
+		_, err = resolver.getZone("bar.example.org")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, lister.calls)
+	})
+
+	t.Run("Should cache the result of a zone finder", func(t *testing.T) {
+		calls := 0
+		resolver := newZoneFinderResolver(func(string) (string, error) {
+			calls++
+			return "lab.example.com.", nil
+		})
+		for range 2 {
+			output, err := resolver.getZone("foo.lab.example.com")
+			assert.NoError(t, err)
+			assert.Equal(t, "lab.example.com", output)
+		}
+		assert.Equal(t, 1, calls)
+	})
+}
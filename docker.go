@@ -5,18 +5,41 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
 	docker "github.com/docker/docker/client"
+	"github.com/wdullaer/dd-dns/store"
+	"github.com/wdullaer/dd-dns/stringslice"
 	"github.com/wdullaer/dd-dns/types"
+	"go.uber.org/zap"
 	tailscale "tailscale.com/client/local"
 )
 
+const (
+	// networkLabel is the docker label that selects the network of which the container IP is published
+	networkLabel = "dd-dns.network"
+	// proxiedLabel is the docker label that routes the traffic of the records of the container through the Cloudflare proxy
+	proxiedLabel = "dd-dns.cloudflare.proxied"
+	// ttlLabel is the docker label that sets the TTL (in seconds) of the records of the container
+	ttlLabel = "dd-dns.ttl"
+	// reconnectMinDelay is the time we wait before the first attempt to reconnect to docker
+	reconnectMinDelay = time.Second
+	// reconnectMaxDelay caps the exponential backoff between attempts to reconnect to docker
+	reconnectMaxDelay = time.Minute
+)
+
 func syncDNSWithDocker(state *State) error {
+	// Containers can also use indexed labels, so we can't filter on the label here
 	args := filters.NewArgs()
-	args.Add("label", state.Config.DockerLabel)
 	args.Add("status", "running")
 	containerList, err := state.DockerClient.ContainerList(context.Background(), container.ListOptions{
 		Filters: args,
@@ -25,80 +48,268 @@ func syncDNSWithDocker(state *State) error {
 		return err
 	}
 
-	mappingList := make([]*types.DNSMapping, len(containerList))
+	mappingList := make([]*types.DNSMapping, 0, len(containerList))
+	running := make(map[string]bool, len(containerList))
 	for i, container := range containerList {
-		ip, err := getIP(&containerList[i], state.Config.DNSContent)
+		ready, err := isContainerReady(state.DockerClient, &containerList[i])
 		if err != nil {
-			state.Logger.Errorw("Failed to obtain IP address for container", "containerId", container.ID, "err", err)
+			state.Logger.Errorw("Failed to obtain the health of container", "containerId", container.ID, "err", err)
 			continue
 		}
-		mappingList[i] = &types.DNSMapping{
-			Name:        container.Labels[state.Config.DockerLabel],
-			IP:          ip,
-			ContainerID: container.ID,
+		if !ready {
+			state.Logger.Infow("Waiting for container to become healthy", "containerId", container.ID)
+			continue
+		}
+		running[container.ID] = true
+		mappings, err := getContainerMappings(&containerList[i], state.Config, state.Logger)
+		if err != nil {
+			state.Logger.Errorw("Failed to obtain DNS mappings for container", "containerId", container.ID, "err", err)
+			continue
 		}
+		mappingList = append(mappingList, mappings...)
 	}
 
+	// Swarm services are stored like containers, under their service ID
+	if state.Config.SwarmServices != "" {
+		serviceMappings, err := getSwarmMappings(state)
+		if err != nil {
+			return err
+		}
+		for _, mapping := range serviceMappings {
+			running[mapping.ContainerID] = true
+		}
+		mappingList = append(mappingList, serviceMappings...)
+	}
+
+	// Stopped containers that are still in their grace period keep their mappings
+	pendingMappings, err := getPendingMappings(running, mappingList, state)
+	if err != nil {
+		return err
+	}
+	mappingList = append(mappingList, pendingMappings...)
+	mappingList = filterMappings(mappingList, state)
+
 	state.Logger.Infow("Setting new mappings", "mappings", mappingList)
-	return state.Store.ReplaceMappings(mappingList, state.Provider)
+	if err := state.Store.ReplaceMappings(mappingList, state.Provider); err != nil {
+		return err
+	}
+	// The full docker state has been applied, so any operation that is still queued is outdated
+	return state.Store.ClearOperations()
 }
 
 func processDockerEvent(event events.Message, state *State) error {
-	container, err := getContainerByID(state.DockerClient, event.Actor.ID)
+	if event.Type == events.ServiceEventType {
+		return processServiceEvent(event, state)
+	}
+
+	switch event.Action {
+	case "start":
+		return addContainerMappings(event.Actor.ID, state)
+	case "health_status: healthy":
+		// Containers that don't require to be healthy were published when they started
+		if required, _ := requiresHealthy(event.Actor.Attributes); required {
+			return addContainerMappings(event.Actor.ID, state)
+		}
+	case "health_status: unhealthy":
+		if required, _ := requiresHealthy(event.Actor.Attributes); required {
+			state.Logger.Infow("Container became unhealthy, withdrawing its mappings", "containerId", event.Actor.ID)
+			return removeContainerMappings(event.Actor.ID, state)
+		}
+	case "die":
+		return scheduleContainerRemoval(event, state)
+	case "connect", "disconnect":
+		return processNetworkEvent(event, state)
+	case "health_status: starting", "health_status: running":
+		// Only the transitions to healthy and unhealthy change the records of a container
+	case "create", "update", "remove":
+		// These are subscribed to for services, containers are published when they start
+	default:
+		state.Logger.Warnw("Unsupported event", "event", event.Action)
+	}
+
+	return nil
+}
+
+// addContainerMappings adds the mappings of a container that started or became healthy
+// Containers that require to be healthy are skipped until their healthcheck reports healthy
+func addContainerMappings(containerID string, state *State) error {
+	container, err := getContainerByID(state.DockerClient, containerID)
 	if err != nil {
 		state.Logger.Errorw("Could not obtain container details", "err", err)
 		return nil
 	}
 
-	ip, err := getIP(container, state.Config.DNSContent)
+	ready, err := isContainerReady(state.DockerClient, container)
 	if err != nil {
-		state.Logger.Errorw("Could not obtain container IP", "err", err)
+		state.Logger.Errorw("Could not obtain container health", "err", err)
+		return nil
+	}
+	if !ready {
+		state.Logger.Infow("Waiting for container to become healthy", "containerId", containerID)
 		return nil
 	}
 
-	mapping := &types.DNSMapping{
-		Name:        event.Actor.Attributes[state.Config.DockerLabel],
-		IP:          ip,
-		ContainerID: event.Actor.ID,
+	mappings, err := getContainerMappings(container, state.Config, state.Logger)
+	if err != nil {
+		state.Logger.Errorw("Could not obtain container DNS mappings", "err", err)
+		return nil
 	}
+	mappings = filterMappings(mappings, state)
 
-	switch event.Action {
-	case "start":
+	// A failed mapping is queued to be retried, it does not prevent the others from being added
+	errs := []error{}
+	for _, mapping := range mappings {
 		state.Logger.Infow("Insert into store", "mapping", mapping)
-		err = state.Store.InsertMapping(mapping, state.Provider.AddHostnameMapping)
-		if err != nil {
-			return err
+		if err := store.ApplyOperation(state.Store, state.Provider, &store.Operation{Action: store.OperationAdd, Mapping: mapping}); err != nil {
+			errs = append(errs, err)
 		}
-	case "die":
-		state.Logger.Infow("Remove from store", "mapping", mapping)
-		err := state.Store.RemoveMapping(mapping, state.Provider.RemoveHostnameMapping)
-		if err != nil {
-			return err
+	}
+	errs = append(errs, reclaimPendingRemovals(container.ID, mappings, state))
+	return errors.Join(errs...)
+}
+
+// removeContainerMappings removes all mappings the store has registered for a container
+// A stopped container no longer has any IP addresses, so we can't recompute its mappings
+// Records that are shared with other containers stay in place until their last container is removed
+// Queued operations of the container are dropped, so a failed add can't be retried after the container is gone
+func removeContainerMappings(containerID string, state *State) error {
+	if err := state.Store.DeleteContainerOperations(containerID); err != nil {
+		return err
+	}
+	mappings, err := state.Store.GetContainerMappings(containerID)
+	if err != nil {
+		return err
+	}
+
+	// A failed mapping is queued to be retried, it does not prevent the others from being removed
+	errs := []error{}
+	for _, mapping := range mappings {
+		if err := removeMapping(mapping, state); err != nil {
+			errs = append(errs, err)
 		}
-	default:
-		state.Logger.Warnw("Unsupported event", "event", event.Action)
+	}
+	return errors.Join(errs...)
+}
+
+// processNetworkEvent recomputes the IPs of a container that was connected to or disconnected from a network
+// and moves its mappings in the store from the old IPs to the new ones
+func processNetworkEvent(event events.Message, state *State) error {
+	// The IPs of a container only depend on its networks if we publish the container IP
+	if state.Config.DNSContent != dnsContentContainer {
+		return nil
+	}
+
+	containerID := event.Actor.Attributes["container"]
+	container, err := getContainerByID(state.DockerClient, containerID)
+	if err != nil {
+		// Stopped containers are disconnected from their networks as well, the die event takes care of those
+		state.Logger.Debugw("Could not obtain container details", "containerId", containerID, "err", err)
+		return nil
+	}
+	// Containers that are waiting to become healthy have not been published yet
+	if ready, err := isContainerReady(state.DockerClient, container); err != nil || !ready {
+		state.Logger.Debugw("Container is not ready to be published", "containerId", containerID, "err", err)
+		return nil
+	}
+
+	mappings, err := getContainerMappings(container, state.Config, state.Logger)
+	if err != nil {
+		// The container can no longer be reached on the network we publish, so none of its mappings are valid
+		state.Logger.Warnw("Could not obtain container DNS mappings, removing them", "containerId", containerID, "err", err)
+		mappings = nil
+	}
+
+	state.Logger.Infow("Update container mappings", "containerId", containerID, "network", event.Actor.Attributes["name"], "mappings", mappings)
+	return updateContainerMappings(containerID, mappings, state)
+}
+
+// updateContainerMappings replaces the mappings of a container (or service) in the store with the supplied list
+// Mappings outside of the allowed domains are dropped. If this fails, all of its changes are queued to be retried
+func updateContainerMappings(containerID string, mappings []*types.DNSMapping, state *State) error {
+	mappings = filterMappings(mappings, state)
+	currentMappings, err := state.Store.GetContainerMappings(containerID)
+	if err != nil {
+		return err
 	}
 
+	if err := state.Store.UpdateContainerMappings(containerID, mappings, state.Provider); err != nil {
+		// The update can be applied partially, so queue all of its changes to be retried (applied ones are no-ops)
+		errs := []error{err}
+		for _, mapping := range mappings {
+			if !types.HasDNSMapping(currentMappings, mapping) {
+				errs = append(errs, store.QueueOperation(state.Store, &store.Operation{Action: store.OperationAdd, Mapping: mapping}, time.Now()))
+			}
+		}
+		for _, mapping := range currentMappings {
+			if !types.HasDNSMapping(mappings, mapping) {
+				errs = append(errs, store.QueueOperation(state.Store, &store.Operation{Action: store.OperationRemove, Mapping: mapping}, time.Now()))
+			}
+		}
+		return errors.Join(errs...)
+	}
 	return nil
 }
 
-func makeDockerChannels(client *docker.Client, config *config) (<-chan events.Message, <-chan error) {
+// makeDockerChannels subscribes to the docker events dd-dns is interested in
+// If since is not the zero time, events that happened since then are replayed first
+// If services is true, the events of swarm services are included as well
+func makeDockerChannels(client *docker.Client, since time.Time, services bool) (<-chan events.Message, <-chan error) {
 	args := filters.NewArgs()
 	args.Add("scope", "swarm")
 	args.Add("scope", "local")
-	// args.Add("type", "service") // service is created and deleted, we should probably special case this through some config
+	if services {
+		args.Add("type", "service")
+		args.Add("event", "create")
+		args.Add("event", "update")
+		args.Add("event", "remove")
+	}
 	args.Add("type", "container")
+	// Connecting a container to a network (or disconnecting it) might change its IP
+	args.Add("type", "network")
 	// args.Add("type", "config") // TODO: check what triggers these events
 	args.Add("event", "start")
 	args.Add("event", "die")
-	// args.Add("event", "update") // Only services are updated
-	args.Add("label", config.DockerLabel)
+	args.Add("event", "connect")
+	args.Add("event", "disconnect")
+	// Containers with the dd-dns.require-healthy label are only published while they are healthy
+	args.Add("event", "health_status")
+	// Containers can also use indexed labels, so we can't filter on the label here
 
-	// TODO: also listen to network/connect and network/disconnect messages, as these might change the IP of a container
+	options := events.ListOptions{Filters: args}
+	if !since.IsZero() {
+		options.Since = fmt.Sprintf("%d.%09d", since.Unix(), since.Nanosecond())
+	}
+	return client.Events(context.Background(), options)
+}
 
-	return client.Events(context.Background(), events.ListOptions{
-		Filters: args,
-	})
+// reconnectDocker reopens the docker event stream, resuming from the given time so no events are missed
+// It retries with an exponential backoff until it succeeds, or until a signal is received (in which case it returns false)
+// After reconnecting the full docker state is synced, to close any gap the replayed events can't cover
+func reconnectDocker(state *State, since time.Time, signalChan <-chan os.Signal) (<-chan events.Message, <-chan error, bool) {
+	delay := reconnectMinDelay
+	for {
+		state.Logger.Infow("Reconnecting to docker", "delay", delay)
+		select {
+		case <-time.After(delay):
+		case sig := <-signalChan:
+			state.Logger.Infow("Received signal to terminate", "sig", sig)
+			return nil, nil, false
+		}
+
+		if _, err := state.DockerClient.Ping(context.Background()); err != nil {
+			state.Logger.Errorw("Failed to reconnect to docker", "err", err)
+			delay = min(2*delay, reconnectMaxDelay)
+			continue
+		}
+
+		eventChan, errorChan := makeDockerChannels(state.DockerClient, since, state.Config.SwarmServices != "")
+		state.Logger.Infow("Reconnected to docker", "since", since)
+		// A failure here is a store or provider issue, rather than a docker one, so we keep the connection
+		if err := syncDNSWithDocker(state); err != nil {
+			state.Logger.Errorw("Failed to sync with docker after reconnecting", "err", err)
+		}
+		return eventChan, errorChan, true
+	}
 }
 
 // getContainerByID retrieves a Container Object. Returns an error if the container is not found
@@ -117,35 +328,188 @@ func getContainerByID(client *docker.Client, id string) (*container.Summary, err
 	return &containers[0], nil
 }
 
-// getIP returns an IP address for a given container. How the IP is determined is driven by mode:
-//   - If mode is `container`: the IP address of the container in the first network is returned
-//   - If mode is `tailscale`: the IPv4 address of the current tailnet is returned
-//   - If mode is an IP address: that IP address is parsed and returned
-func getIP(container *container.Summary, mode string) (net.IP, error) {
+// getContainerMappings returns a DNSMapping for every combination of hostname and IP address of the container
+// Containers without any hostname labels, that don't opt in to the hostname-template, don't have any mappings
+// Invalid hostnames are logged and skipped
+func getContainerMappings(container *container.Summary, config *config, logger *zap.SugaredLogger) ([]*types.DNSMapping, error) {
+	logger = logger.With("containerId", container.ID)
+	hostnames := getAllHostnames(container.Labels, config, logger)
+	hostnames = appendTemplateHostnames(hostnames, newContainerTemplateData(container), config, logger)
+	if len(hostnames) == 0 {
+		return nil, nil
+	}
+
+	network := config.DefaultNetwork
+	if label, ok := container.Labels[networkLabel]; ok {
+		network = strings.TrimSpace(label)
+	}
+	ips, err := getIPs(container, config.DNSContent, network)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := getRecordSettings(container.Labels, "container "+getContainerName(container))
+	if err != nil {
+		return nil, err
+	}
+
+	mappings := make([]*types.DNSMapping, 0, len(hostnames)*len(ips))
+	for _, hostname := range hostnames {
+		for _, ip := range ips {
+			mapping := types.NewDNSMapping(hostname, ip, container.ID)
+			mapping.Settings = settings
+			mappings = append(mappings, mapping)
+		}
+	}
+	return mappings, nil
+}
+
+// getRecordSettings returns the settings of the DNS records of a container or service, based on its labels
+// Every record gets a comment that refers to the container or service that created it (eg: `container web`)
+func getRecordSettings(labels map[string]string, owner string) (types.RecordSettings, error) {
+	settings := types.RecordSettings{Comment: types.ManagedComment + ", " + owner}
+	if label, ok := labels[proxiedLabel]; ok {
+		proxied, err := strconv.ParseBool(strings.TrimSpace(label))
+		if err != nil {
+			return settings, fmt.Errorf("invalid %s label `%s`, it must be a boolean", proxiedLabel, label)
+		}
+		settings.Proxied = proxied
+	}
+	if label, ok := labels[ttlLabel]; ok {
+		ttl, err := strconv.Atoi(strings.TrimSpace(label))
+		if err != nil || ttl <= 0 {
+			return settings, fmt.Errorf("invalid %s label `%s`, it must be a positive number of seconds", ttlLabel, label)
+		}
+		settings.TTL = ttl
+	}
+	return settings, nil
+}
+
+// getContainerName returns the name of the container without the leading slash
+// It falls back to the short container ID if the container has no name
+func getContainerName(container *container.Summary) string {
+	if len(container.Names) != 0 {
+		return strings.TrimPrefix(container.Names[0], "/")
+	}
+	if len(container.ID) > 12 {
+		return container.ID[:12]
+	}
+	return container.ID
+}
+
+// getHostnames returns the hostnames in the label and its indexed variants (eg: `dd-dns.hostname.1`)
+// Every label can contain multiple hostnames, separated by commas or whitespace
+// The hostnames are returned in the order of their label index, without duplicates
+func getHostnames(labels map[string]string, label string) []string {
+	keys := []string{}
+	if _, ok := labels[label]; ok {
+		keys = append(keys, label)
+	}
+	indexedKeys := []string{}
+	for key := range labels {
+		if index, ok := strings.CutPrefix(key, label+"."); ok && isIndex(index) {
+			indexedKeys = append(indexedKeys, key)
+		}
+	}
+	sort.Slice(indexedKeys, func(i, j int) bool {
+		a, _ := strconv.Atoi(strings.TrimPrefix(indexedKeys[i], label+"."))
+		b, _ := strconv.Atoi(strings.TrimPrefix(indexedKeys[j], label+"."))
+		return a < b
+	})
+	keys = append(keys, indexedKeys...)
+
+	hostnames := []string{}
+	for _, key := range keys {
+		for _, hostname := range strings.FieldsFunc(labels[key], isHostnameSeparator) {
+			if !stringslice.Contains(hostnames, hostname) {
+				hostnames = append(hostnames, hostname)
+			}
+		}
+	}
+	return hostnames
+}
+
+// isIndex returns true if the string is a non-negative integer
+func isIndex(s string) bool {
+	_, err := strconv.ParseUint(s, 10, 32)
+	return err == nil
+}
+
+// isHostnameSeparator returns true for the characters that can separate hostnames in a label
+func isHostnameSeparator(r rune) bool {
+	return r == ',' || unicode.IsSpace(r)
+}
+
+// getIPs returns the IP addresses for a given container. How the IPs are determined is driven by mode:
+//   - If mode is `container`: the IPv4 and global IPv6 address of the container in the given network are returned
+//     If no network is given, the first network (in alphabetical order) that has an IP address is used
+//   - If mode is `tailscale`: the IPv4 and IPv6 addresses of the current tailnet are returned
+//   - If mode is a list of IP addresses: those IP addresses are parsed and returned
+func getIPs(container *container.Summary, mode string, networkName string) ([]net.IP, error) {
 	switch mode {
 	case "container":
-		// TODO: look at a docker label for the network to use (return first if not set)
-		for _, network := range container.NetworkSettings.Networks {
-			if network.IPAddress != "" {
-				return net.ParseIP(network.IPAddress), nil
+		if container.NetworkSettings == nil {
+			return nil, errors.New("container has no internal IP addresses")
+		}
+		if networkName != "" {
+			settings, ok := container.NetworkSettings.Networks[networkName]
+			if !ok || settings == nil {
+				return nil, fmt.Errorf("container is not attached to network `%s`", networkName)
+			}
+			ips := getNetworkIPs(settings)
+			if len(ips) == 0 {
+				return nil, fmt.Errorf("container has no IP addresses in network `%s`", networkName)
+			}
+			return ips, nil
+		}
+
+		// Sort the networks, so we return the same IPs for a container with multiple networks every time
+		names := make([]string, 0, len(container.NetworkSettings.Networks))
+		for name := range container.NetworkSettings.Networks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if settings := container.NetworkSettings.Networks[name]; settings != nil {
+				if ips := getNetworkIPs(settings); len(ips) != 0 {
+					return ips, nil
+				}
 			}
 		}
 		return nil, errors.New("container has no internal IP addresses")
 	case "tailscale":
 		status, err := tailscale.StatusWithoutPeers(context.Background())
+		if err != nil {
+			return nil, err
+		}
 		if status.CurrentTailnet == nil {
 			return nil, errors.New("not connected to tailscale")
 		}
-		if err != nil {
-			return nil, err
+		ips := make([]net.IP, len(status.TailscaleIPs))
+		for i, ip := range status.TailscaleIPs {
+			ips[i] = net.IP(ip.AsSlice())
 		}
-		for _, ip := range status.TailscaleIPs {
-			if ip.Is4() {
-				return net.IP(ip.AsSlice()), nil
-			}
+		if len(ips) == 0 {
+			return nil, errors.New("no tailscale IP address found")
 		}
-		return nil, errors.New("no tailscale IPv4 address found")
+		return ips, nil
 	default:
-		return net.ParseIP(mode), nil
+		parts := strings.Split(mode, ",")
+		ips := make([]net.IP, len(parts))
+		for i := range parts {
+			ips[i] = net.ParseIP(parts[i])
+		}
+		return ips, nil
+	}
+}
+
+// getNetworkIPs returns the IPv4 and global IPv6 address of a container in a network, if they are set
+func getNetworkIPs(settings *network.EndpointSettings) []net.IP {
+	ips := []net.IP{}
+	if settings.IPAddress != "" {
+		ips = append(ips, net.ParseIP(settings.IPAddress))
+	}
+	if settings.GlobalIPv6Address != "" {
+		ips = append(ips, net.ParseIP(settings.GlobalIPv6Address))
 	}
+	return ips
 }
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/wdullaer/dd-dns/dns"
+	"github.com/wdullaer/dd-dns/store"
+	"github.com/wdullaer/dd-dns/types"
+	"go.uber.org/zap"
+)
+
+func TestGetHostnames(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    map[string]string
+		expected []string
+	}{
+		{
+			name:     "Should return an empty slice if the label is not present",
+			input:    map[string]string{"foo": "bar"},
+			expected: []string{},
+		},
+		{
+			name:     "Should return the hostname in the label",
+			input:    map[string]string{"dd-dns.hostname": "foo.example.com"},
+			expected: []string{"foo.example.com"},
+		},
+		{
+			name:     "Should split a comma separated list of hostnames",
+			input:    map[string]string{"dd-dns.hostname": "foo.example.com,bar.example.com"},
+			expected: []string{"foo.example.com", "bar.example.com"},
+		},
+		{
+			name:     "Should split a whitespace separated list of hostnames",
+			input:    map[string]string{"dd-dns.hostname": " foo.example.com\tbar.example.com , baz.example.com "},
+			expected: []string{"foo.example.com", "bar.example.com", "baz.example.com"},
+		},
+		{
+			name: "Should return the hostnames of indexed labels in order",
+			input: map[string]string{
+				"dd-dns.hostname.10": "baz.example.com",
+				"dd-dns.hostname.2":  "bar.example.com",
+				"dd-dns.hostname":    "foo.example.com",
+			},
+			expected: []string{"foo.example.com", "bar.example.com", "baz.example.com"},
+		},
+		{
+			name: "Should ignore labels with a non numeric suffix",
+			input: map[string]string{
+				"dd-dns.hostname":     "foo.example.com",
+				"dd-dns.hostname.foo": "bar.example.com",
+			},
+			expected: []string{"foo.example.com"},
+		},
+		{
+			name: "Should remove duplicate hostnames",
+			input: map[string]string{
+				"dd-dns.hostname":   "foo.example.com,bar.example.com",
+				"dd-dns.hostname.1": "foo.example.com",
+			},
+			expected: []string{"foo.example.com", "bar.example.com"},
+		},
+		{
+			name:     "Should return an empty slice for an empty label",
+			input:    map[string]string{"dd-dns.hostname": " , "},
+			expected: []string{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			output := getHostnames(tc.input, "dd-dns.hostname")
+			assert.Equal(t, tc.expected, output)
+		})
+	}
+}
+
+func TestGetIPs(t *testing.T) {
+	input := &container.Summary{
+		NetworkSettings: &container.NetworkSettingsSummary{
+			Networks: map[string]*network.EndpointSettings{
+				"frontend": {IPAddress: "172.18.0.2", GlobalIPv6Address: "fd00::2"},
+				"backend":  {IPAddress: "172.19.0.2"},
+				"empty":    {},
+			},
+		},
+	}
+
+	cases := []struct {
+		name         string
+		inputMode    string
+		inputNetwork string
+		expected     []net.IP
+		error        bool
+	}{
+		{
+			name:         "Should return the IPs of the first network in alphabetical order if no network is given",
+			inputMode:    "container",
+			inputNetwork: "",
+			expected:     []net.IP{net.ParseIP("172.19.0.2")},
+		},
+		{
+			name:         "Should return the IPv4 and IPv6 address of the given network",
+			inputMode:    "container",
+			inputNetwork: "frontend",
+			expected:     []net.IP{net.ParseIP("172.18.0.2"), net.ParseIP("fd00::2")},
+		},
+		{
+			name:         "Should return an error if the container is not attached to the given network",
+			inputMode:    "container",
+			inputNetwork: "unknown",
+			error:        true,
+		},
+		{
+			name:         "Should return an error if the container has no IPs in the given network",
+			inputMode:    "container",
+			inputNetwork: "empty",
+			error:        true,
+		},
+		{
+			name:         "Should return the given IP addresses and ignore the network",
+			inputMode:    "192.168.0.1,2001:db8::1",
+			inputNetwork: "frontend",
+			expected:     []net.IP{net.ParseIP("192.168.0.1"), net.ParseIP("2001:db8::1")},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := getIPs(input, tc.inputMode, tc.inputNetwork)
+			if tc.error {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expected, output)
+		})
+	}
+}
+
+func TestGetRecordSettings(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    container.Summary
+		expected types.RecordSettings
+		error    bool
+	}{
+		{
+			name:     "Should only set a comment if no labels are present",
+			input:    container.Summary{ID: "0123456789abcdef", Names: []string{"/web"}},
+			expected: types.RecordSettings{Comment: "managed by dd-dns, container web"},
+		},
+		{
+			name:     "Should fall back to the short container ID in the comment",
+			input:    container.Summary{ID: "0123456789abcdef"},
+			expected: types.RecordSettings{Comment: "managed by dd-dns, container 0123456789ab"},
+		},
+		{
+			name: "Should read the proxied and ttl labels",
+			input: container.Summary{
+				Names:  []string{"/web"},
+				Labels: map[string]string{proxiedLabel: "true", ttlLabel: " 120 "},
+			},
+			expected: types.RecordSettings{TTL: 120, Proxied: true, Comment: "managed by dd-dns, container web"},
+		},
+		{
+			name:  "Should return an error for an invalid proxied label",
+			input: container.Summary{Names: []string{"/web"}, Labels: map[string]string{proxiedLabel: "maybe"}},
+			error: true,
+		},
+		{
+			name:  "Should return an error for a ttl label that is not a positive number",
+			input: container.Summary{Names: []string{"/web"}, Labels: map[string]string{ttlLabel: "-5"}},
+			error: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := getRecordSettings(tc.input.Labels, "container "+getContainerName(&tc.input))
+			if tc.error {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, output)
+		})
+	}
+}
+
+func TestRemoveContainerMappings(t *testing.T) {
+	ip := net.ParseIP("100.64.0.1")
+	containers := map[string][]string{
+		"foo": {"a.example.com", "b.example.com"},
+		"bar": {"b.example.com", "c.example.com"},
+	}
+	logger := zap.NewNop().Sugar()
+	memoryStore, err := store.NewMemoryStore(logger)
+	if !assert.NoError(t, err) {
+		return
+	}
+	boltdbStore, err := store.NewBoltDBStore(logger, t.TempDir())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer boltdbStore.CleanUp()
+
+	for name, db := range map[string]store.Store{"memory": memoryStore, "boltdb": boltdbStore} {
+		t.Run(name, func(t *testing.T) {
+			provider, _ := dns.NewDryrunProvider(logger)
+			state := &State{Store: db, Provider: provider, Logger: logger}
+			for containerID, hostnames := range containers {
+				for _, hostname := range hostnames {
+					assert.NoError(t, db.InsertMapping(types.NewDNSMapping(hostname, ip, containerID), provider.AddHostnameMapping))
+				}
+			}
+
+			// Only the name that is not shared with bar disappears
+			assert.NoError(t, removeContainerMappings("foo", state))
+			assert.Equal(t, map[string][]net.IP{"b.example.com": {ip}, "c.example.com": {ip}}, provider.Zone)
+			mappings, err := db.GetContainerMappings("foo")
+			assert.NoError(t, err)
+			assert.Empty(t, mappings)
+
+			assert.NoError(t, removeContainerMappings("bar", state))
+			assert.Empty(t, provider.Zone)
+		})
+	}
+}
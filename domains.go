@@ -0,0 +1,55 @@
+package main
+
+import (
+	"path"
+	"strings"
+
+	"github.com/wdullaer/dd-dns/types"
+)
+
+// isDomainAllowed returns true if dd-dns may publish the hostname, based on the allowed-domains and denied-domains
+// A hostname that matches a denied pattern is never allowed. If allowed-domains is set, the hostname must match one of them
+func isDomainAllowed(hostname string, config *config) bool {
+	hostname = strings.TrimSuffix(strings.ToLower(hostname), ".")
+	for _, pattern := range config.getDeniedDomains() {
+		if matchDomain(hostname, pattern) {
+			return false
+		}
+	}
+	allowed := config.getAllowedDomains()
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, pattern := range allowed {
+		if matchDomain(hostname, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchDomain returns true if the hostname matches a domain pattern
+// A pattern with a wildcard (`*` or `?`) is a glob that has to match the complete hostname (eg: `*.apps.example.com`)
+// Any other pattern is a suffix that matches the domain and all of its subdomains (eg: `example.com`)
+func matchDomain(hostname string, pattern string) bool {
+	if strings.ContainsAny(pattern, "*?[") {
+		matched, _ := path.Match(pattern, hostname)
+		return matched
+	}
+	return hostname == pattern || strings.HasSuffix(hostname, "."+pattern)
+}
+
+// filterMappings returns the mappings whose hostname dd-dns may publish
+// Rejected mappings are logged and counted in the state
+func filterMappings(mappings []*types.DNSMapping, state *State) []*types.DNSMapping {
+	filtered := make([]*types.DNSMapping, 0, len(mappings))
+	for _, mapping := range mappings {
+		if isDomainAllowed(mapping.Name, state.Config) {
+			filtered = append(filtered, mapping)
+			continue
+		}
+		rejected := state.RejectedMappings.Add(1)
+		state.Logger.Warnw("Rejected mapping outside of the allowed domains", "mapping", mapping, "containerId", mapping.ContainerID, "rejectedMappings", rejected)
+	}
+	return filtered
+}
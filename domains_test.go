@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wdullaer/dd-dns/types"
+	"go.uber.org/zap"
+)
+
+func TestIsDomainAllowed(t *testing.T) {
+	cases := []struct {
+		name     string
+		allowed  string
+		denied   string
+		input    string
+		expected bool
+	}{
+		{
+			name:     "Should allow every domain without any patterns",
+			input:    "www.example.com",
+			expected: true,
+		},
+		{
+			name:     "Should allow a subdomain of an allowed suffix",
+			allowed:  "apps.example.com",
+			input:    "web.apps.example.com",
+			expected: true,
+		},
+		{
+			name:     "Should allow the allowed suffix itself",
+			allowed:  "apps.example.com",
+			input:    "apps.example.com",
+			expected: true,
+		},
+		{
+			name:     "Should only match a suffix on a label boundary",
+			allowed:  "example.com",
+			input:    "badexample.com",
+			expected: false,
+		},
+		{
+			name:     "Should reject a domain that is not allowed",
+			allowed:  "apps.example.com",
+			input:    "www.example.com",
+			expected: false,
+		},
+		{
+			name:     "Should match a glob against the complete hostname",
+			allowed:  "*.apps.example.com",
+			input:    "apps.example.com",
+			expected: false,
+		},
+		{
+			name:     "Should let a denied pattern take precedence",
+			allowed:  "example.com",
+			denied:   "www.example.com",
+			input:    "www.example.com",
+			expected: false,
+		},
+		{
+			name:     "Should reject a denied glob without allowed domains",
+			denied:   "*.internal.example.com",
+			input:    "db.internal.example.com",
+			expected: false,
+		},
+		{
+			name:     "Should ignore case and a trailing dot",
+			allowed:  "example.com",
+			denied:   "www.example.com",
+			input:    "WWW.Example.com.",
+			expected: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			output := isDomainAllowed(tc.input, &config{AllowedDomains: tc.allowed, DeniedDomains: tc.denied})
+			assert.Equal(t, tc.expected, output)
+		})
+	}
+}
+
+func TestFilterMappings(t *testing.T) {
+	state := &State{
+		Config: &config{AllowedDomains: "apps.example.com", DeniedDomains: "www.apps.example.com"},
+		Logger: zap.NewNop().Sugar(),
+	}
+	allowed := types.NewDNSMapping("web.apps.example.com", net.ParseIP("192.168.0.1"), "foo")
+	mappings := []*types.DNSMapping{
+		allowed,
+		types.NewDNSMapping("www.apps.example.com", net.ParseIP("192.168.0.1"), "foo"),
+		types.NewDNSMapping("www.example.com", net.ParseIP("192.168.0.1"), "foo"),
+	}
+
+	assert.Equal(t, []*types.DNSMapping{allowed}, filterMappings(mappings, state))
+	assert.Equal(t, uint64(2), state.RejectedMappings.Load())
+}
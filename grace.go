@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/wdullaer/dd-dns/store"
+	"github.com/wdullaer/dd-dns/stringslice"
+	"github.com/wdullaer/dd-dns/types"
+)
+
+// gracePeriodLabel is the docker label that overrides the grace-period option for a container
+const gracePeriodLabel = "dd-dns.grace-period"
+
+// pendingRemovals maps the containers that stopped to the time at which their mappings are removed
+// Until then their records stay in place, so a container restart does not make its hostnames disappear
+type pendingRemovals map[string]time.Time
+
+// scheduleContainerRemoval removes the mappings of a stopped container once its grace period expires
+// Without a grace period they are removed right away
+func scheduleContainerRemoval(event events.Message, state *State) error {
+	containerID := event.Actor.ID
+	gracePeriod := getContainerGracePeriod(event.Actor.Attributes, state)
+	if gracePeriod == 0 {
+		return removeContainerMappings(containerID, state)
+	}
+
+	mappings, err := state.Store.GetContainerMappings(containerID)
+	if err != nil {
+		return err
+	}
+	if len(mappings) == 0 {
+		return removeContainerMappings(containerID, state)
+	}
+	state.Logger.Infow("Removing container mappings after the grace period", "containerId", containerID, "gracePeriod", gracePeriod)
+	state.PendingRemovals[containerID] = time.Now().Add(gracePeriod)
+	return nil
+}
+
+// getContainerGracePeriod returns the grace period of the container, based on its labels
+// An invalid label is logged and the grace-period option is used instead
+func getContainerGracePeriod(labels map[string]string, state *State) time.Duration {
+	label, ok := labels[gracePeriodLabel]
+	if !ok {
+		return state.Config.getGracePeriod()
+	}
+	gracePeriod, err := time.ParseDuration(strings.TrimSpace(label))
+	if err != nil || gracePeriod < 0 {
+		state.Logger.Warnw("Invalid grace period label, it must be a duration that is not negative (eg: `30s`)", "label", gracePeriodLabel, "value", label)
+		return state.Config.getGracePeriod()
+	}
+	return gracePeriod
+}
+
+// processPendingRemovals removes the mappings of the stopped containers whose grace period expired
+func processPendingRemovals(state *State, now time.Time) error {
+	errs := []error{}
+	for containerID, deadline := range state.PendingRemovals {
+		if deadline.After(now) {
+			continue
+		}
+		delete(state.PendingRemovals, containerID)
+		state.Logger.Infow("Grace period expired, removing container mappings", "containerId", containerID)
+		errs = append(errs, removeContainerMappings(containerID, state))
+	}
+	return errors.Join(errs...)
+}
+
+// reclaimPendingRemovals cancels the pending removals that are superseded by a container that started
+// If the container itself restarted, its pending removal is cancelled and only the mappings it no longer has are removed
+// If it claims the hostname of another stopped container, the mappings of that container for the hostname are removed
+// right away: the hostname is served by the new container, so it never disappears
+func reclaimPendingRemovals(containerID string, mappings []*types.DNSMapping, state *State) error {
+	errs := []error{}
+	if _, ok := state.PendingRemovals[containerID]; ok {
+		delete(state.PendingRemovals, containerID)
+		state.Logger.Infow("Container restarted, cancelled the removal of its mappings", "containerId", containerID)
+		currentMappings, err := state.Store.GetContainerMappings(containerID)
+		if err != nil {
+			return err
+		}
+		for _, mapping := range currentMappings {
+			if !types.HasDNSMapping(mappings, mapping) {
+				errs = append(errs, removeMapping(mapping, state))
+			}
+		}
+	}
+
+	names := getMappingNames(mappings)
+	for pendingID := range state.PendingRemovals {
+		pendingMappings, err := state.Store.GetContainerMappings(pendingID)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		remaining := len(pendingMappings)
+		for _, mapping := range pendingMappings {
+			if stringslice.Contains(names, mapping.Name) {
+				state.Logger.Infow("Hostname was claimed by another container, cancelled the pending removal", "mapping", mapping, "containerId", containerID)
+				errs = append(errs, removeMapping(mapping, state))
+				remaining--
+			}
+		}
+		if remaining == 0 {
+			delete(state.PendingRemovals, pendingID)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// getPendingMappings returns the mappings of the stopped containers that are still in their grace period
+// A full sync includes these, so it does not remove them early
+// Pending removals of containers that are running again are cancelled, and so are the mappings of hostnames
+// that are claimed by a running container
+func getPendingMappings(running map[string]bool, mappings []*types.DNSMapping, state *State) ([]*types.DNSMapping, error) {
+	names := getMappingNames(mappings)
+	pendingMappings := []*types.DNSMapping{}
+	for containerID := range state.PendingRemovals {
+		if running[containerID] {
+			delete(state.PendingRemovals, containerID)
+			continue
+		}
+		containerMappings, err := state.Store.GetContainerMappings(containerID)
+		if err != nil {
+			return nil, err
+		}
+		kept := 0
+		for _, mapping := range containerMappings {
+			if !stringslice.Contains(names, mapping.Name) {
+				pendingMappings = append(pendingMappings, mapping)
+				kept++
+			}
+		}
+		if kept == 0 {
+			delete(state.PendingRemovals, containerID)
+		}
+	}
+	return pendingMappings, nil
+}
+
+// removeMapping removes a single mapping, queueing it to be retried if it fails
+func removeMapping(mapping *types.DNSMapping, state *State) error {
+	state.Logger.Infow("Remove from store", "mapping", mapping)
+	return store.ApplyOperation(state.Store, state.Provider, &store.Operation{Action: store.OperationRemove, Mapping: mapping})
+}
+
+// getMappingNames returns the hostnames of the mappings, without duplicates
+func getMappingNames(mappings []*types.DNSMapping) []string {
+	names := []string{}
+	for _, mapping := range mappings {
+		if !stringslice.Contains(names, mapping.Name) {
+			names = append(names, mapping.Name)
+		}
+	}
+	return names
+}
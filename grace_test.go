@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/wdullaer/dd-dns/dns"
+	"github.com/wdullaer/dd-dns/store"
+	"github.com/wdullaer/dd-dns/types"
+	"go.uber.org/zap"
+)
+
+// newGraceTestState returns a State with a memory store in which container foo has a.example.com and b.example.com
+func newGraceTestState(t *testing.T, gracePeriod string) (*State, *dns.DryrunProvider) {
+	logger := zap.NewNop().Sugar()
+	db, err := store.NewMemoryStore(logger)
+	if err != nil {
+		t.Fatalf("Failed to create memory store: %s", err)
+	}
+	provider, _ := dns.NewDryrunProvider(logger)
+	state := &State{
+		Config:          &config{GracePeriod: gracePeriod},
+		Store:           db,
+		Provider:        provider,
+		Logger:          logger,
+		PendingRemovals: pendingRemovals{},
+		PendingServices: pendingServices{},
+	}
+	for _, hostname := range []string{"a.example.com", "b.example.com"} {
+		if err := db.InsertMapping(types.NewDNSMapping(hostname, net.ParseIP("192.168.0.1"), "foo"), provider.AddHostnameMapping); err != nil {
+			t.Fatalf("Failed to insert mapping: %s", err)
+		}
+	}
+	return state, provider
+}
+
+func TestGetContainerGracePeriod(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    map[string]string
+		expected time.Duration
+	}{
+		{
+			name:     "Should default to the grace-period option",
+			input:    map[string]string{},
+			expected: time.Minute,
+		},
+		{
+			name:     "Should use the label",
+			input:    map[string]string{gracePeriodLabel: " 30s "},
+			expected: 30 * time.Second,
+		},
+		{
+			name:     "Should allow the label to disable the grace period",
+			input:    map[string]string{gracePeriodLabel: "0"},
+			expected: 0,
+		},
+		{
+			name:     "Should ignore an invalid label",
+			input:    map[string]string{gracePeriodLabel: "-5m"},
+			expected: time.Minute,
+		},
+	}
+
+	state, _ := newGraceTestState(t, "1m0s")
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, getContainerGracePeriod(tc.input, state))
+		})
+	}
+}
+
+func TestScheduleContainerRemoval(t *testing.T) {
+	t.Run("Should remove the mappings right away without a grace period", func(t *testing.T) {
+		state, provider := newGraceTestState(t, "0s")
+		assert.NoError(t, scheduleContainerRemoval(events.Message{Action: "die", Actor: events.Actor{ID: "foo"}}, state))
+		assert.Empty(t, state.PendingRemovals)
+		assert.Empty(t, provider.Zone)
+	})
+
+	t.Run("Should remove the mappings once the grace period expired", func(t *testing.T) {
+		state, provider := newGraceTestState(t, "1m0s")
+		assert.NoError(t, scheduleContainerRemoval(events.Message{Action: "die", Actor: events.Actor{ID: "foo"}}, state))
+		assert.Len(t, provider.Zone, 2)
+
+		assert.NoError(t, processPendingRemovals(state, time.Now()))
+		assert.Len(t, provider.Zone, 2)
+
+		assert.NoError(t, processPendingRemovals(state, time.Now().Add(time.Minute)))
+		assert.Empty(t, state.PendingRemovals)
+		assert.Empty(t, provider.Zone)
+	})
+}
+
+func TestReclaimPendingRemovals(t *testing.T) {
+	t.Run("Should cancel the removal when the container restarts", func(t *testing.T) {
+		state, provider := newGraceTestState(t, "1m0s")
+		assert.NoError(t, scheduleContainerRemoval(events.Message{Action: "die", Actor: events.Actor{ID: "foo"}}, state))
+
+		// The container came back without b.example.com
+		mappings := []*types.DNSMapping{types.NewDNSMapping("a.example.com", net.ParseIP("192.168.0.1"), "foo")}
+		assert.NoError(t, reclaimPendingRemovals("foo", mappings, state))
+		assert.Empty(t, state.PendingRemovals)
+		assert.Equal(t, map[string][]net.IP{"a.example.com": {net.ParseIP("192.168.0.1")}}, provider.Zone)
+	})
+
+	t.Run("Should hand over a hostname that another container claims", func(t *testing.T) {
+		state, provider := newGraceTestState(t, "1m0s")
+		assert.NoError(t, scheduleContainerRemoval(events.Message{Action: "die", Actor: events.Actor{ID: "foo"}}, state))
+
+		mapping := types.NewDNSMapping("a.example.com", net.ParseIP("192.168.0.2"), "bar")
+		assert.NoError(t, state.Store.InsertMapping(mapping, provider.AddHostnameMapping))
+		assert.NoError(t, reclaimPendingRemovals("bar", []*types.DNSMapping{mapping}, state))
+		assert.Contains(t, state.PendingRemovals, "foo")
+		assert.Equal(t, map[string][]net.IP{
+			"a.example.com": {net.ParseIP("192.168.0.2")},
+			"b.example.com": {net.ParseIP("192.168.0.1")},
+		}, provider.Zone)
+	})
+}
+
+func TestGetPendingMappings(t *testing.T) {
+	state, _ := newGraceTestState(t, "1m0s")
+	state.PendingRemovals["foo"] = time.Now().Add(time.Minute)
+	state.PendingRemovals["baz"] = time.Now().Add(time.Minute)
+
+	running := map[string]bool{"bar": true, "baz": true}
+	mappings := []*types.DNSMapping{types.NewDNSMapping("a.example.com", net.ParseIP("192.168.0.2"), "bar")}
+	output, err := getPendingMappings(running, mappings, state)
+	assert.NoError(t, err)
+	assert.Equal(t, []*types.DNSMapping{types.NewDNSMapping("b.example.com", net.ParseIP("192.168.0.1"), "foo")}, output)
+	// baz is running again, so its removal is cancelled
+	assert.Equal(t, []string{"foo"}, getPendingIDs(state))
+}
+
+// getPendingIDs returns the ContainerIDs of the pending removals
+func getPendingIDs(state *State) []string {
+	ids := []string{}
+	for containerID := range state.PendingRemovals {
+		ids = append(ids, containerID)
+	}
+	return ids
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	docker "github.com/docker/docker/client"
+)
+
+// healthyLabel is the docker label that only publishes the records of a container while its healthcheck reports healthy
+const healthyLabel = "dd-dns.require-healthy"
+
+// requiresHealthy returns true if the container only publishes its records while it is healthy, based on its labels
+func requiresHealthy(labels map[string]string) (bool, error) {
+	label, ok := labels[healthyLabel]
+	if !ok {
+		return false, nil
+	}
+	required, err := strconv.ParseBool(strings.TrimSpace(label))
+	if err != nil {
+		return false, fmt.Errorf("invalid %s label `%s`, it must be a boolean", healthyLabel, label)
+	}
+	return required, nil
+}
+
+// isContainerReady returns true if the records of the container can be published
+// Containers that require to be healthy are inspected, since the container list does not include their health
+func isContainerReady(client *docker.Client, summary *container.Summary) (bool, error) {
+	required, err := requiresHealthy(summary.Labels)
+	if err != nil || !required {
+		return !required, err
+	}
+	inspect, err := client.ContainerInspect(context.Background(), summary.ID)
+	if err != nil {
+		return false, err
+	}
+	return isHealthy(inspect.State), nil
+}
+
+// isHealthy returns true if the healthcheck of the container reports healthy
+// The require-healthy label has no effect on containers without a healthcheck, so they are always considered healthy
+func isHealthy(state *container.State) bool {
+	if state == nil || state.Health == nil {
+		return true
+	}
+	return state.Health.Status == container.Healthy
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequiresHealthy(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    map[string]string
+		expected bool
+		error    bool
+	}{
+		{
+			name:     "Should not require a container without the label to be healthy",
+			input:    map[string]string{},
+			expected: false,
+			error:    false,
+		},
+		{
+			name:     "Should parse the label",
+			input:    map[string]string{healthyLabel: " true "},
+			expected: true,
+			error:    false,
+		},
+		{
+			name:     "Should reject a label that is not a boolean",
+			input:    map[string]string{healthyLabel: "yes please"},
+			expected: false,
+			error:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := requiresHealthy(tc.input)
+			if tc.error {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expected, output)
+		})
+	}
+}
+
+func TestIsHealthy(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    *container.State
+		expected bool
+	}{
+		{
+			name:     "Should consider a container without a healthcheck healthy",
+			input:    &container.State{Running: true},
+			expected: true,
+		},
+		{
+			name:     "Should not consider a starting container healthy",
+			input:    &container.State{Running: true, Health: &container.Health{Status: container.Starting}},
+			expected: false,
+		},
+		{
+			name:     "Should consider a healthy container healthy",
+			input:    &container.State{Running: true, Health: &container.Health{Status: container.Healthy}},
+			expected: true,
+		},
+		{
+			name:     "Should not consider an unhealthy container healthy",
+			input:    &container.State{Running: true, Health: &container.Health{Status: container.Unhealthy}},
+			expected: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, isHealthy(tc.input))
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/wdullaer/dd-dns/stringslice"
+	"github.com/wdullaer/dd-dns/types"
+	"go.uber.org/zap"
+)
+
+const (
+	// hostnameSourceLabel reads the hostnames from the docker-label and its indexed variants
+	hostnameSourceLabel = "label"
+	// hostnameSourceTraefik reads the hostnames from the Host and HostSNI rules of the traefik routers
+	hostnameSourceTraefik = "traefik"
+	// hostnameSourceCaddy reads the hostnames from the site addresses of the caddy-docker-proxy labels
+	hostnameSourceCaddy = "caddy"
+)
+
+var (
+	// traefikRuleLabel matches the labels that contain the rule of a traefik http or tcp router
+	traefikRuleLabel = regexp.MustCompile(`^traefik\.(http|tcp)\.routers\.[^.]+\.rule$`)
+	// traefikHostMatcher matches the Host, HostHeader and HostSNI matchers of a traefik rule, and their arguments
+	traefikHostMatcher = regexp.MustCompile("(!\\s*)?\\b(?:Host|HostHeader|HostSNI)\\s*\\(([^)]*)\\)")
+	// traefikArgument matches a backtick or double quoted argument of a traefik matcher
+	traefikArgument = regexp.MustCompile("`([^`]*)`|\"([^\"]*)\"")
+	// caddyLabel matches the labels of caddy-docker-proxy that contain site addresses (eg: `caddy` or `caddy_1`)
+	caddyLabel = regexp.MustCompile(`^caddy(_\d+)?$`)
+)
+
+// getAllHostnames returns the hostnames of a container or service from all the configured hostname sources
+// The hostnames are normalized and returned in the order of the sources, without duplicates
+// Invalid hostnames are logged and skipped, so they don't prevent the valid ones from being published
+func getAllHostnames(labels map[string]string, config *config, logger *zap.SugaredLogger) []string {
+	hostnames := []string{}
+	for _, source := range config.getHostnameSources() {
+		var sourceHostnames []string
+		switch source {
+		case hostnameSourceLabel:
+			sourceHostnames = getHostnames(labels, config.DockerLabel)
+		case hostnameSourceTraefik:
+			sourceHostnames = getTraefikHostnames(labels)
+		case hostnameSourceCaddy:
+			sourceHostnames = getCaddyHostnames(labels)
+		}
+		hostnames = appendHostnames(hostnames, sourceHostnames, source, logger)
+	}
+	return hostnames
+}
+
+// appendHostnames normalizes the hostnames of a source and appends them to list, without duplicates
+// Invalid hostnames are logged and skipped
+func appendHostnames(list []string, hostnames []string, source string, logger *zap.SugaredLogger) []string {
+	for _, hostname := range hostnames {
+		normalized, err := types.NormalizeHostname(hostname)
+		if err != nil {
+			logger.Warnw("Skipping invalid hostname", "source", source, "hostname", hostname, "err", err)
+			continue
+		}
+		if !stringslice.Contains(list, normalized) {
+			list = append(list, normalized)
+		}
+	}
+	return list
+}
+
+// getTraefikHostnames returns the hostnames in the Host, HostHeader and HostSNI matchers of the traefik router rules
+// (eg: traefik.http.routers.web.rule=Host(`a.example.com`) || Host(`b.example.com`))
+// Negated matchers and the HostSNI(`*`) catch-all are skipped, and so are containers with traefik.enable=false
+// The hostnames are returned in the order of the router names, without duplicates
+func getTraefikHostnames(labels map[string]string) []string {
+	if enabled, err := strconv.ParseBool(strings.TrimSpace(labels["traefik.enable"])); err == nil && !enabled {
+		return []string{}
+	}
+
+	keys := []string{}
+	for key := range labels {
+		if traefikRuleLabel.MatchString(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	hostnames := []string{}
+	for _, key := range keys {
+		for _, matcher := range traefikHostMatcher.FindAllStringSubmatch(labels[key], -1) {
+			if matcher[1] != "" {
+				continue
+			}
+			for _, argument := range traefikArgument.FindAllStringSubmatch(matcher[2], -1) {
+				hostname := strings.TrimSpace(argument[1] + argument[2])
+				if hostname != "" && hostname != "*" && !stringslice.Contains(hostnames, hostname) {
+					hostnames = append(hostnames, hostname)
+				}
+			}
+		}
+	}
+	return hostnames
+}
+
+// getCaddyHostnames returns the hostnames in the site addresses of the caddy-docker-proxy labels
+// (eg: caddy=a.example.com, b.example.com or caddy_1=https://*.example.com:8443)
+// Schemes, ports and paths are stripped. Snippets, addresses without a hostname, IP addresses, localhost and placeholders are skipped
+// The hostnames are returned in the order of their label index, without duplicates
+func getCaddyHostnames(labels map[string]string) []string {
+	keys := []string{}
+	for key := range labels {
+		if caddyLabel.MatchString(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return getCaddyLabelIndex(keys[i]) < getCaddyLabelIndex(keys[j])
+	})
+
+	hostnames := []string{}
+	for _, key := range keys {
+		// Snippets (eg: `caddy_0=(common)`) are imported by sites, they don't have an address themselves
+		if value := strings.TrimSpace(labels[key]); strings.HasPrefix(value, "(") && strings.HasSuffix(value, ")") {
+			continue
+		}
+		for _, address := range strings.FieldsFunc(labels[key], isHostnameSeparator) {
+			if hostname, ok := parseCaddyAddress(address); ok && !stringslice.Contains(hostnames, hostname) {
+				hostnames = append(hostnames, hostname)
+			}
+		}
+	}
+	return hostnames
+}
+
+// getCaddyLabelIndex returns the index of a caddy label, the unindexed `caddy` label comes first
+func getCaddyLabelIndex(key string) int {
+	index, err := strconv.Atoi(strings.TrimPrefix(key, "caddy_"))
+	if err != nil {
+		return -1
+	}
+	return index
+}
+
+// parseCaddyAddress returns the hostname of a caddy site address (eg: `https://a.example.com:8443/api`)
+// Returns false if the address does not contain a hostname that can be published
+func parseCaddyAddress(address string) (string, bool) {
+	if _, rest, ok := strings.Cut(address, "://"); ok {
+		address = rest
+	}
+	address, _, _ = strings.Cut(address, "/")
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	address = strings.ToLower(address)
+	if address == "" || address == "localhost" || strings.ContainsAny(address, "{}[]:()") || net.ParseIP(address) != nil {
+		return "", false
+	}
+	return address, true
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestGetAllHostnames(t *testing.T) {
+	labels := map[string]string{
+		"dd-dns.hostname":                 "a.example.com",
+		"traefik.http.routers.web.rule":   "Host(`a.example.com`) || Host(`b.example.com`)",
+		"traefik.http.routers.other.rule": "Host(`C.Example.com.`)",
+		"caddy":                           "my_app.example.com, d.example.com",
+	}
+	cases := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			name:     "Should only read the docker label by default",
+			input:    "label",
+			expected: []string{"a.example.com"},
+		},
+		{
+			name:     "Should combine the normalized sources in order without duplicates",
+			input:    "label,traefik",
+			expected: []string{"a.example.com", "c.example.com", "b.example.com"},
+		},
+		{
+			name:     "Should skip an invalid hostname and keep the valid ones",
+			input:    "label,caddy",
+			expected: []string{"a.example.com", "d.example.com"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			output := getAllHostnames(labels, &config{DockerLabel: "dd-dns.hostname", HostnameSources: tc.input}, zap.NewNop().Sugar())
+			assert.Equal(t, tc.expected, output)
+		})
+	}
+}
+
+func TestGetTraefikHostnames(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    map[string]string
+		expected []string
+	}{
+		{
+			name:     "Should return an empty slice if there are no routers",
+			input:    map[string]string{"traefik.enable": "true"},
+			expected: []string{},
+		},
+		{
+			name:     "Should read all hosts of a rule",
+			input:    map[string]string{"traefik.http.routers.web.rule": "Host(`a.example.com`) || (Host(`b.example.com`) && PathPrefix(`/api`))"},
+			expected: []string{"a.example.com", "b.example.com"},
+		},
+		{
+			name:     "Should read a Host matcher with multiple arguments",
+			input:    map[string]string{"traefik.http.routers.web.rule": "Host(`a.example.com`, \"b.example.com\")"},
+			expected: []string{"a.example.com", "b.example.com"},
+		},
+		{
+			name: "Should read the HostSNI matchers of tcp routers, except the catch-all",
+			input: map[string]string{
+				"traefik.tcp.routers.db.rule":  "HostSNI(`db.example.com`)",
+				"traefik.tcp.routers.all.rule": "HostSNI(`*`)",
+			},
+			expected: []string{"db.example.com"},
+		},
+		{
+			name:     "Should skip negated and regexp matchers",
+			input:    map[string]string{"traefik.http.routers.web.rule": "HostRegexp(`{sub:[a-z]+}.example.com`) && !Host(`admin.example.com`)"},
+			expected: []string{},
+		},
+		{
+			name: "Should sort by router name and skip duplicates",
+			input: map[string]string{
+				"traefik.http.routers.b.rule": "Host(`b.example.com`) || Host(`a.example.com`)",
+				"traefik.http.routers.a.rule": "Host(`a.example.com`)",
+			},
+			expected: []string{"a.example.com", "b.example.com"},
+		},
+		{
+			name: "Should skip containers that are disabled in traefik",
+			input: map[string]string{
+				"traefik.enable":                "false",
+				"traefik.http.routers.web.rule": "Host(`a.example.com`)",
+			},
+			expected: []string{},
+		},
+		{
+			name:     "Should ignore labels that are not router rules",
+			input:    map[string]string{"traefik.http.services.web.loadbalancer.server.port": "80"},
+			expected: []string{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, getTraefikHostnames(tc.input))
+		})
+	}
+}
+
+func TestGetCaddyHostnames(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    map[string]string
+		expected []string
+	}{
+		{
+			name:     "Should return an empty slice if there are no caddy labels",
+			input:    map[string]string{"caddy.reverse_proxy": "{{upstreams 80}}"},
+			expected: []string{},
+		},
+		{
+			name:     "Should split a label on commas and whitespace",
+			input:    map[string]string{"caddy": "a.example.com, b.example.com c.example.com"},
+			expected: []string{"a.example.com", "b.example.com", "c.example.com"},
+		},
+		{
+			name: "Should read the numbered variants in order of their index",
+			input: map[string]string{
+				"caddy_10": "c.example.com",
+				"caddy_2":  "b.example.com",
+				"caddy":    "a.example.com",
+				"caddy_0":  "a.example.com",
+			},
+			expected: []string{"a.example.com", "b.example.com", "c.example.com"},
+		},
+		{
+			name:     "Should strip schemes, ports and paths",
+			input:    map[string]string{"caddy": "https://A.example.com:8443, http://b.example.com/api, c.example.com:80"},
+			expected: []string{"a.example.com", "b.example.com", "c.example.com"},
+		},
+		{
+			name:     "Should keep wildcards",
+			input:    map[string]string{"caddy": "*.example.com"},
+			expected: []string{"*.example.com"},
+		},
+		{
+			name:     "Should skip addresses without a publishable hostname",
+			input:    map[string]string{"caddy": ":80, localhost, 192.168.0.1, [::1]:443, {$DOMAIN}, http://"},
+			expected: []string{},
+		},
+		{
+			name: "Should skip snippets",
+			input: map[string]string{
+				"caddy_0":        "(common)",
+				"caddy_0.encode": "gzip",
+				"caddy_1":        "a.example.com",
+				"caddy_1.import": "common",
+			},
+			expected: []string{"a.example.com"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, getCaddyHostnames(tc.input))
+		})
+	}
+}
@@ -5,6 +5,11 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
+
+	"github.com/wdullaer/dd-dns/dns"
+	"github.com/wdullaer/dd-dns/store"
+	"github.com/wdullaer/dd-dns/types"
 )
 
 func main() {
@@ -28,29 +33,135 @@ func main() {
 	}
 	defer state.Store.CleanUp()
 
+	// Finish the changes that were interrupted when the previous run crashed
+	if err := state.Store.RecoverOutbox(state.Provider); err != nil {
+		logger.Errorw("Failed to recover pending changes, the failed changes are queued to be retried", "err", err)
+	}
+
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
 
-	// TODO: maybe regularly sync with docker
+	// Replay the events that happen during the initial sync, so we don't miss any
+	lastEventTime := time.Now()
 	if err := syncDNSWithDocker(state); err != nil {
 		logger.Fatalw("Failed to get initial docker state", "err", err)
 	}
+	if configuration.AdoptRecords {
+		adoptDNSRecords(state)
+	}
+	repairDNSDrift(state)
+
+	eventChan, errorChan := makeDockerChannels(state.DockerClient, lastEventTime, configuration.SwarmServices != "")
+
+	// Regularly sync the full docker state, so missed or mis-ordered events get corrected
+	// This happens in the event loop, so it can never run concurrently with the processing of an event
+	var resyncChan <-chan time.Time
+	if interval := configuration.getResyncInterval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		resyncChan = ticker.C
+	}
+
+	// Regularly retry the changes that failed, so a provider outage doesn't leave DNS out of sync
+	retryTicker := time.NewTicker(retryQueueInterval)
+	defer retryTicker.Stop()
+
+	// Regularly remove the records of stopped containers whose grace period expired
+	pendingRemovalTicker := time.NewTicker(pendingRemovalInterval)
+	defer pendingRemovalTicker.Stop()
 
-	eventChan, errorChan := makeDockerChannels(state.DockerClient, state.Config)
+	// Regularly check the swarm services that are waiting for their tasks to start
+	pendingServiceTicker := time.NewTicker(pendingServiceInterval)
+	defer pendingServiceTicker.Stop()
 main:
 	for {
 		select {
 		case event := <-eventChan:
+			lastEventTime = time.Unix(0, event.TimeNano)
 			err := processDockerEvent(event, state)
 			if err != nil {
-				state.Logger.Errorw("Failed to process docker event", "err", err)
+				state.Logger.Errorw("Failed to process docker event, the failed changes are queued to be retried", "err", err)
 			}
 		case err := <-errorChan:
-			state.Logger.Fatalw("Received a docker error", "err", err)
-			break main
+			state.Logger.Errorw("Received a docker error", "err", err)
+			var connected bool
+			if eventChan, errorChan, connected = reconnectDocker(state, lastEventTime, signalChan); !connected {
+				break main
+			}
+		case <-resyncChan:
+			state.Logger.Infow("Resyncing with docker")
+			if err := syncDNSWithDocker(state); err != nil {
+				state.Logger.Errorw("Failed to resync with docker", "err", err)
+			}
+			repairDNSDrift(state)
+		case <-retryTicker.C:
+			drainRetryQueue(state)
+		case now := <-pendingRemovalTicker.C:
+			if err := processPendingRemovals(state, now); err != nil {
+				state.Logger.Errorw("Failed to remove the mappings of stopped containers, the failed changes are queued to be retried", "err", err)
+			}
+		case <-pendingServiceTicker.C:
+			if err := processPendingServices(state); err != nil {
+				state.Logger.Errorw("Failed to publish the services whose tasks started, the failed changes are queued to be retried", "err", err)
+			}
 		case sig := <-signalChan:
 			state.Logger.Infow("Received signal to terminate", "sig", sig)
 			break main
 		}
 	}
 }
+
+// drainRetryQueue retries the changes that failed before and are due
+func drainRetryQueue(state *State) {
+	applied, err := store.DrainRetryQueue(state.Store, state.Provider, time.Now())
+	if len(applied) != 0 {
+		state.Logger.Infow("Retried queued changes", "operations", applied)
+	}
+	if err != nil {
+		state.Logger.Errorw("Failed to retry queued changes, they will be retried later", "err", err)
+	}
+}
+
+// repairDNSDrift restores any records that were modified at the DNS provider outside of dd-dns
+func repairDNSDrift(state *State) {
+	report, err := store.RepairDrift(state.Store, state.Provider)
+	if err != nil {
+		state.Logger.Errorw("Failed to repair drift between the store and the DNS provider", "err", err)
+	}
+	if report == nil {
+		return
+	}
+	if report.HasChanges() {
+		state.Logger.Warnw("Repaired drift between the store and the DNS provider", "created", report.Created, "updated", report.Updated, "removed", report.Removed)
+	}
+	if len(report.Unmanaged) != 0 {
+		state.Logger.Warnw("Found records that were not created by dd-dns, leaving them alone", "records", report.Unmanaged)
+	}
+	if len(report.Skipped) != 0 {
+		state.Logger.Debugw("Skipped records that are owned by someone else", "records", report.Skipped)
+	}
+}
+
+// adoptDNSRecords claims ownership of the records in the store that have no owner yet
+// This takes over the records that were created before an owner id was configured
+func adoptDNSRecords(state *State) {
+	registry, ok := state.Provider.(*dns.OwnershipRegistry)
+	if !ok {
+		return
+	}
+	records, err := state.Store.GetRecords()
+	if err != nil {
+		state.Logger.Errorw("Failed to adopt existing records", "err", err)
+		return
+	}
+	mappings := make([]*types.DNSMapping, len(records))
+	for i, record := range records {
+		mappings[i] = &types.DNSMapping{Name: record.Name, Type: record.Type, IP: record.IP, Settings: record.Settings}
+	}
+	adopted, err := registry.AdoptRecords(mappings)
+	if err != nil {
+		state.Logger.Errorw("Failed to adopt existing records", "adopted", adopted, "err", err)
+		return
+	}
+	state.Logger.Infow("Adopted existing records", "records", adopted)
+}
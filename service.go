@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/swarm"
+	docker "github.com/docker/docker/client"
+	"github.com/wdullaer/dd-dns/types"
+	"go.uber.org/zap"
+)
+
+// errNoRunningTasks is returned for a service without running tasks in the nodes mode of swarm-services
+var errNoRunningTasks = errors.New("service has no running tasks")
+
+// pendingServices holds the swarm services that can't be published yet, because none of their tasks is running
+// Swarm sends no service event when the tasks of a service start, so these services are checked again regularly
+type pendingServices map[string]struct{}
+
+// getSwarmMappings returns the mappings of all swarm services that have a hostname label
+// Services whose mappings can't be determined are logged and skipped, services without running tasks become pending
+func getSwarmMappings(state *State) ([]*types.DNSMapping, error) {
+	services, err := state.DockerClient.ServiceList(context.Background(), swarm.ServiceListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	clear(state.PendingServices)
+	mappingList := []*types.DNSMapping{}
+	for i := range services {
+		mappings, err := getServiceMappings(state.DockerClient, &services[i], state.Config, state.Logger)
+		if errors.Is(err, errNoRunningTasks) {
+			// Like updateServiceMappings, the service keeps its current mappings until its tasks start
+			state.Logger.Infow("Waiting for the tasks of service to start", "serviceId", services[i].ID)
+			state.PendingServices[services[i].ID] = struct{}{}
+			mappings, err = state.Store.GetContainerMappings(services[i].ID)
+			if err != nil {
+				return nil, err
+			}
+		} else if err != nil {
+			state.Logger.Errorw("Failed to obtain DNS mappings for service", "serviceId", services[i].ID, "err", err)
+			continue
+		}
+		mappingList = append(mappingList, mappings...)
+	}
+	return mappingList, nil
+}
+
+// processServiceEvent updates the mappings of a swarm service that was created, updated or removed
+// The mappings of a service are stored under its ID, like the mappings of a container
+func processServiceEvent(event events.Message, state *State) error {
+	serviceID := event.Actor.ID
+	switch event.Action {
+	case "create", "update":
+		return updateServiceMappings(serviceID, state)
+	case "remove":
+		delete(state.PendingServices, serviceID)
+		return removeContainerMappings(serviceID, state)
+	default:
+		state.Logger.Warnw("Unsupported service event", "event", event.Action)
+		return nil
+	}
+}
+
+// processPendingServices publishes the pending services whose tasks started in the meantime
+func processPendingServices(state *State) error {
+	serviceIDs := make([]string, 0, len(state.PendingServices))
+	for serviceID := range state.PendingServices {
+		serviceIDs = append(serviceIDs, serviceID)
+	}
+	errs := []error{}
+	for _, serviceID := range serviceIDs {
+		errs = append(errs, updateServiceMappings(serviceID, state))
+	}
+	return errors.Join(errs...)
+}
+
+// updateServiceMappings replaces the mappings of a swarm service with the ones it currently has
+// A service without running tasks keeps its current mappings and becomes pending until its tasks start
+func updateServiceMappings(serviceID string, state *State) error {
+	service, _, err := state.DockerClient.ServiceInspectWithRaw(context.Background(), serviceID, swarm.ServiceInspectOptions{})
+	if err != nil {
+		if cerrdefs.IsNotFound(err) {
+			delete(state.PendingServices, serviceID)
+		}
+		state.Logger.Errorw("Could not obtain service details", "serviceId", serviceID, "err", err)
+		return nil
+	}
+	mappings, err := getServiceMappings(state.DockerClient, &service, state.Config, state.Logger)
+	if errors.Is(err, errNoRunningTasks) {
+		if _, ok := state.PendingServices[serviceID]; !ok {
+			state.Logger.Infow("Waiting for the tasks of service to start", "serviceId", serviceID)
+			state.PendingServices[serviceID] = struct{}{}
+		}
+		return nil
+	}
+	delete(state.PendingServices, serviceID)
+	if err != nil {
+		state.Logger.Errorw("Could not obtain service DNS mappings", "serviceId", serviceID, "err", err)
+		return nil
+	}
+	state.Logger.Infow("Update service mappings", "serviceId", serviceID, "mappings", mappings)
+	return updateContainerMappings(serviceID, mappings, state)
+}
+
+// getServiceMappings returns a DNSMapping for every combination of hostname and IP address of the service
+// Services without any hostname labels, that don't opt in to the hostname-template, don't have any mappings
+// Invalid hostnames are logged and skipped
+func getServiceMappings(client *docker.Client, service *swarm.Service, config *config, logger *zap.SugaredLogger) ([]*types.DNSMapping, error) {
+	logger = logger.With("serviceId", service.ID)
+	hostnames := getAllHostnames(service.Spec.Labels, config, logger)
+	hostnames = appendTemplateHostnames(hostnames, newServiceTemplateData(service), config, logger)
+	if len(hostnames) == 0 {
+		return nil, nil
+	}
+
+	ips, err := getServiceIPs(client, service, config)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := getRecordSettings(service.Spec.Labels, "service "+service.Spec.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	mappings := make([]*types.DNSMapping, 0, len(hostnames)*len(ips))
+	for _, hostname := range hostnames {
+		for _, ip := range ips {
+			mapping := types.NewDNSMapping(hostname, ip, service.ID)
+			mapping.Settings = settings
+			mappings = append(mappings, mapping)
+		}
+	}
+	return mappings, nil
+}
+
+// getServiceIPs returns the IP addresses of a service. How the IPs are determined is driven by the swarm-services option:
+//   - If it is `vip`: the virtual IP of the service in the network of the `dd-dns.network` label or the default network
+//     If no network is given, the virtual IP of the first network of the service is used
+//   - If it is `nodes`: the IPs of the nodes that run a task of the service
+func getServiceIPs(client *docker.Client, service *swarm.Service, config *config) ([]net.IP, error) {
+	if config.SwarmServices == swarmServicesNodes {
+		args := filters.NewArgs()
+		args.Add("service", service.ID)
+		args.Add("desired-state", "running")
+		tasks, err := client.TaskList(context.Background(), swarm.TaskListOptions{Filters: args})
+		if err != nil {
+			return nil, err
+		}
+		nodes, err := client.NodeList(context.Background(), swarm.NodeListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		ips := getTaskNodeIPs(tasks, nodes)
+		if len(ips) == 0 {
+			return nil, errNoRunningTasks
+		}
+		return ips, nil
+	}
+
+	networkName := config.DefaultNetwork
+	if label, ok := service.Spec.Labels[networkLabel]; ok {
+		networkName = strings.TrimSpace(label)
+	}
+	networkID := ""
+	if networkName != "" {
+		inspect, err := client.NetworkInspect(context.Background(), networkName, network.InspectOptions{})
+		if err != nil {
+			return nil, err
+		}
+		networkID = inspect.ID
+	}
+	ip, err := getVirtualIP(service.Endpoint.VirtualIPs, networkID)
+	if err != nil {
+		return nil, err
+	}
+	return []net.IP{ip}, nil
+}
+
+// getVirtualIP returns the virtual IP of a service in the network with the given ID
+// If no network is given, the virtual IP of the first network is returned
+func getVirtualIP(vips []swarm.EndpointVirtualIP, networkID string) (net.IP, error) {
+	for _, vip := range vips {
+		if networkID != "" && vip.NetworkID != networkID {
+			continue
+		}
+		ip, _, err := net.ParseCIDR(vip.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid virtual IP `%s`: %w", vip.Addr, err)
+		}
+		return ip, nil
+	}
+	if networkID != "" {
+		return nil, fmt.Errorf("service has no virtual IP in network `%s`", networkID)
+	}
+	return nil, errors.New("service has no virtual IP, it might use dnsrr endpoint mode")
+}
+
+// getTaskNodeIPs returns the IPs of the nodes that run a task, sorted and without duplicates
+// Managers can report `0.0.0.0` as their address, in which case the address of the manager API is used
+func getTaskNodeIPs(tasks []swarm.Task, nodes []swarm.Node) []net.IP {
+	nodeIPs := map[string]net.IP{}
+	for _, node := range nodes {
+		addr := node.Status.Addr
+		if (addr == "" || addr == "0.0.0.0") && node.ManagerStatus != nil {
+			addr, _, _ = net.SplitHostPort(node.ManagerStatus.Addr)
+		}
+		if ip := net.ParseIP(addr); ip != nil && !ip.IsUnspecified() {
+			nodeIPs[node.ID] = ip
+		}
+	}
+
+	ips := []net.IP{}
+	seen := map[string]bool{}
+	for _, task := range tasks {
+		ip, ok := nodeIPs[task.NodeID]
+		if task.Status.State != swarm.TaskStateRunning || !ok || seen[ip.String()] {
+			continue
+		}
+		seen[ip.String()] = true
+		ips = append(ips, ip)
+	}
+	sort.Slice(ips, func(i, j int) bool {
+		return bytes.Compare(ips[i].To16(), ips[j].To16()) < 0
+	})
+	return ips
+}
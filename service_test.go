@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetVirtualIP(t *testing.T) {
+	vips := []swarm.EndpointVirtualIP{
+		{NetworkID: "ingress", Addr: "10.0.0.5/24"},
+		{NetworkID: "web", Addr: "10.0.1.7/24"},
+	}
+	cases := []struct {
+		name     string
+		input    []swarm.EndpointVirtualIP
+		inputNet string
+		expected net.IP
+		error    bool
+	}{
+		{
+			name:     "Should return the virtual IP of the first network by default",
+			input:    vips,
+			expected: net.ParseIP("10.0.0.5"),
+		},
+		{
+			name:     "Should return the virtual IP of the given network",
+			input:    vips,
+			inputNet: "web",
+			expected: net.ParseIP("10.0.1.7"),
+		},
+		{
+			name:     "Should return an error if the service is not attached to the network",
+			input:    vips,
+			inputNet: "backend",
+			error:    true,
+		},
+		{
+			name:  "Should return an error if the service has no virtual IP",
+			input: nil,
+			error: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := getVirtualIP(tc.input, tc.inputNet)
+			if tc.error {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, tc.expected.Equal(output), "Expected `%s`, got `%s`", tc.expected, output)
+		})
+	}
+}
+
+func TestGetTaskNodeIPs(t *testing.T) {
+	nodes := []swarm.Node{
+		{ID: "node-1", Status: swarm.NodeStatus{Addr: "192.168.0.2"}},
+		{ID: "node-2", Status: swarm.NodeStatus{Addr: "0.0.0.0"}, ManagerStatus: &swarm.ManagerStatus{Addr: "192.168.0.1:2377"}},
+		{ID: "node-3", Status: swarm.NodeStatus{Addr: "192.168.0.3"}},
+	}
+	tasks := []swarm.Task{
+		{NodeID: "node-1", Status: swarm.TaskStatus{State: swarm.TaskStateRunning}},
+		{NodeID: "node-1", Status: swarm.TaskStatus{State: swarm.TaskStateRunning}},
+		{NodeID: "node-2", Status: swarm.TaskStatus{State: swarm.TaskStateRunning}},
+		{NodeID: "node-3", Status: swarm.TaskStatus{State: swarm.TaskStatePreparing}},
+	}
+
+	assert.Equal(t, []net.IP{net.ParseIP("192.168.0.1"), net.ParseIP("192.168.0.2")}, getTaskNodeIPs(tasks, nodes))
+}
+
+func TestProcessServiceEventRemove(t *testing.T) {
+	// The grace test state has mappings for foo, which stands in for a service here
+	state, provider := newGraceTestState(t, "0s")
+	state.PendingServices["foo"] = struct{}{}
+
+	event := events.Message{Type: events.ServiceEventType, Action: "remove", Actor: events.Actor{ID: "foo"}}
+	assert.NoError(t, processServiceEvent(event, state))
+	assert.Empty(t, state.PendingServices)
+	assert.Empty(t, provider.Zone)
+}
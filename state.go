@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
+	"time"
 
 	docker "github.com/docker/docker/client"
 	"github.com/wdullaer/dd-dns/dns"
@@ -10,6 +12,17 @@ import (
 	"go.uber.org/zap"
 )
 
+const (
+	retryMinBackoff = time.Second
+	retryMaxBackoff = 2 * time.Minute
+	// retryQueueInterval is the interval at which the queue of failed changes is checked for changes that are due
+	retryQueueInterval = 10 * time.Second
+	// pendingRemovalInterval is the interval at which the grace period of stopped containers is checked
+	pendingRemovalInterval = time.Second
+	// pendingServiceInterval is the interval at which the swarm services without running tasks are checked again
+	pendingServiceInterval = 10 * time.Second
+)
+
 // State is a type that serves as a container for all the state the program
 // manages
 // It makes the signature of functions which act on all of these easier to read
@@ -20,14 +33,22 @@ type State struct {
 	DockerClient *docker.Client
 	Store        store.Store
 	Logger       *zap.SugaredLogger
+	// PendingRemovals is only accessed from the event loop, so it needs no locking
+	PendingRemovals pendingRemovals
+	// PendingServices is only accessed from the event loop, so it needs no locking
+	PendingServices pendingServices
+	// RejectedMappings counts the mappings that were not published, because their hostname is not an allowed domain
+	RejectedMappings atomic.Uint64
 }
 
 // NewState returns a fully initialized application State baed on the
 // configuration options
 func NewState(config *config, logger *zap.SugaredLogger) (*State, error) {
 	state := &State{
-		Config: config,
-		Logger: logger,
+		Config:          config,
+		Logger:          logger,
+		PendingRemovals: pendingRemovals{},
+		PendingServices: pendingServices{},
 	}
 
 	// Connect to docker daemon
@@ -45,6 +66,18 @@ func NewState(config *config, logger *zap.SugaredLogger) (*State, error) {
 	if err != nil {
 		return nil, err
 	}
+	provider = dns.NewRetryProvider(provider, dns.RetryOptions{
+		RateLimit:  config.getRateLimit(),
+		MaxRetries: config.getMaxRetries(),
+		MinBackoff: retryMinBackoff,
+		MaxBackoff: retryMaxBackoff,
+	}, logger)
+	if config.OwnerID != "" {
+		state.Logger.Infow("Tracking record ownership", "owner-id", config.OwnerID)
+		if provider, err = dns.NewOwnershipRegistry(provider, config.OwnerID, logger); err != nil {
+			return nil, err
+		}
+	}
 	state.Provider = provider
 	state.Logger.Infow("Connected to DNS Provider", "provider", config.Provider)
 
@@ -97,6 +130,8 @@ func getDNSProvider(config *config, logger *zap.SugaredLogger) (dns.Provider, er
 		return dns.NewCloudflareProvider(config.AccountName, config.AccountSecret, logger)
 	case providerDryrun:
 		return dns.NewDryrunProvider(logger)
+	case providerRFC2136:
+		return dns.NewRFC2136Provider(config.RFC2136Server, config.AccountName, config.AccountSecret, config.RFC2136TSIGAlgorithm, logger)
 	default:
 		// Since we are eagerly validating the config, this should never happen
 		return nil, fmt.Errorf("invalid provider specified: %s", config.Provider)
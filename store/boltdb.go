@@ -1,8 +1,11 @@
 package store
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"path/filepath"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/wdullaer/dd-dns/dns"
@@ -11,9 +14,16 @@ import (
 	"go.uber.org/zap"
 )
 
-const bucketName = "dns-mapping"
+const (
+	bucketName       = "dns-mapping"
+	queueBucketName  = "retry-queue"
+	outboxBucketName = "outbox"
+)
 
 // BoltDBStore implements the Store interface using a persistent BoltDB instance
+// Changes that require a call to the dns.Provider are first committed to an outbox, the call is made outside
+// of the transaction, and the change is applied once it succeeds. Entries left behind by a crash are
+// recovered with RecoverOutbox
 type BoltDBStore struct {
 	db     *bolt.DB
 	logger *zap.SugaredLogger
@@ -26,14 +36,30 @@ func NewBoltDBStore(logger *zap.SugaredLogger, dataDir string) (*BoltDBStore, er
 		return nil, err
 	}
 
+	logger = logger.Named("boltdb-store")
 	if err := db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte(bucketName))
+		version, err := migrateSchema(tx)
+		if err != nil {
+			return err
+		}
+		if version != schemaVersion {
+			logger.Infow("Migrated the database schema", "from", version, "to", schemaVersion)
+		}
+
+		if _, err := tx.CreateBucketIfNotExists([]byte(bucketName)); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte(queueBucketName)); err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists([]byte(outboxBucketName))
 		return err
 	}); err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	return &BoltDBStore{db: db, logger: logger.Named("boltdb-store")}, nil
+	return &BoltDBStore{db: db, logger: logger}, nil
 }
 
 // CleanUp ensures any pending writes are flushed to disk
@@ -43,141 +69,348 @@ func (store *BoltDBStore) CleanUp() {
 	store.db.Close()
 }
 
-// InsertMapping registers that the ContainerID of the DNSMapping supports an A record
-// In case the A record is not present in the current state, the callback will be executed
-// which should create it at the DNSProvider
+// InsertMapping registers that the ContainerID of the DNSMapping supports an A or AAAA record
+// In case the record is not present in the current state, or its settings changed, the callback will be executed
+// which should create or update it at the DNSProvider
+// The callback is executed outside of the transaction, so a slow provider does not block other writes (see applyOutbox)
 // TODO: maybe pass a dns.Provider, rather than a generic callback
 func (store *BoltDBStore) InsertMapping(dnsMapping *types.DNSMapping, insertCB func(*types.DNSMapping) error) error {
-	return store.db.Update(func(tx *bolt.Tx) error {
+	pending := false
+	err := store.db.Update(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte(bucketName))
-		rawRecord := bucket.Get(dnsMapping.GetKey())
-
-		// New record, save it in db and create in dns provider
-		if rawRecord == nil {
-			payload, err := json.Marshal(types.DNSContainerList{
-				Name:          dnsMapping.Name,
-				IP:            dnsMapping.IP,
-				ContainerList: []string{dnsMapping.ContainerID},
-			})
-			if err != nil {
-				return err
-			}
-			err = bucket.Put(dnsMapping.GetKey(), payload)
-			if err != nil {
-				return err
-			}
-			// Not sure if it's a good idea to keep this IO in the transaction
-			// It does guarantee consistency this way
-			return insertCB(dnsMapping)
+		record, err := getRecord(bucket, dnsMapping)
+		if err != nil {
+			return err
 		}
-		// Record exists, append containerID
-		record := &types.DNSContainerList{}
-		err := json.Unmarshal(rawRecord, record)
+		// New record or changed settings, the dns provider needs to be updated first
+		// The settings of a shared record follow the first container, so they don't flip between containers
+		if record == nil || (record.ContainerList[0] == dnsMapping.ContainerID && record.Settings != dnsMapping.Settings) {
+			pending = true
+			return putOutboxEntry(tx, OperationAdd, dnsMapping)
+		}
+		// Record exists and its settings are up to date, append containerID
+		return putRecord(bucket, dnsMapping.GetKey(), insertRecordMapping(record, dnsMapping))
+	})
+	if err != nil || !pending {
+		return err
+	}
+	return store.applyOutbox(OperationAdd, dnsMapping, insertCB)
+}
+
+// RemoveMapping removes the ContainerID from the list backing the A or AAAA record
+// In case this was the last ContainerID in the list, the record is removed from the dns.Provider
+// Otherwise, if the record has to take the settings of its new first ContainerID, it is updated at the dns.Provider
+// The dns.Provider is called outside of the transaction, so a slow provider does not block other writes (see applyOutbox)
+func (store *BoltDBStore) RemoveMapping(dnsMapping *types.DNSMapping, provider dns.Provider) error {
+	pending := false
+	var update *types.DNSMapping
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(bucketName))
+		record, err := getRecord(bucket, dnsMapping)
 		if err != nil {
 			return err
 		}
-		if !stringslice.Contains(record.ContainerList, dnsMapping.ContainerID) {
-			record.ContainerList = append(record.ContainerList, dnsMapping.ContainerID)
-			payload, err := json.Marshal(record)
-			if err != nil {
-				return err
-			}
-			err = bucket.Put(dnsMapping.GetKey(), payload)
-			if err != nil {
+		if record == nil {
+			store.logger.Warn("BoltDBStore - Tried to remove a mapping that was not present in the store")
+			return nil
+		}
+		// Last mapping of the record, the dns provider needs to be updated first
+		removeRecordContainer(record, dnsMapping.ContainerID)
+		if len(record.ContainerList) == 0 {
+			pending = true
+			return putOutboxEntry(tx, OperationRemove, dnsMapping)
+		}
+		// Still mappings left, the record might have to take the settings of its new first container
+		if update = getSettingsUpdate(record); update != nil {
+			if err := putOutboxEntry(tx, OperationAdd, update); err != nil {
 				return err
 			}
 		}
-		// Record exists, containerID is present
-		return nil
+		return putRecord(bucket, dnsMapping.GetKey(), record)
 	})
+	switch {
+	case err != nil:
+		return err
+	case pending:
+		return store.applyOutbox(OperationRemove, dnsMapping, provider.RemoveHostnameMapping)
+	case update != nil:
+		return store.applyOutbox(OperationAdd, update, provider.AddHostnameMapping)
+	default:
+		return nil
+	}
 }
 
-// RemoveMapping removes the ContainerID from the list backing the A record
-// In case this was the last ContainerID in the list, the callback will be executed
-// to remove the A record from the DNSProvider
-func (store *BoltDBStore) RemoveMapping(dnsMapping *types.DNSMapping, removeCB func(*types.DNSMapping) error) error {
-	return store.db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(bucketName))
-		rawRecord := bucket.Get(dnsMapping.GetKey())
+// UpdateContainerMappings replaces the DNSMappings of a single container with the supplied list
+// New mappings are inserted before the old ones are removed, so a hostname that moves to a new IP always resolves
+// Every mapping is committed separately, since the dns.Provider is called outside of the transactions
+func (store *BoltDBStore) UpdateContainerMappings(containerID string, mappings []*types.DNSMapping, provider dns.Provider) error {
+	currentMappings, err := store.GetContainerMappings(containerID)
+	if err != nil {
+		return err
+	}
+	return applyDiff(store, DiffMappings(mappings, currentMappings), provider)
+}
 
-		if rawRecord == nil {
-			store.logger.Warn("BoltDBStore - Tried to remove a mapping that was not present in the store")
-			return nil
+// RecoverOutbox executes the provider calls of the outbox entries that were left behind by a crash
+// It is not known whether the call was made before the crash, so it is made again (adding or removing a record twice is harmless)
+// Entries whose call fails are pushed onto the retry queue
+func (store *BoltDBStore) RecoverOutbox(provider dns.Provider) error {
+	ops, err := store.getOutbox()
+	if err != nil {
+		return err
+	}
+
+	errs := []error{}
+	for _, op := range ops {
+		store.logger.Infow("Recovering pending operation", "operation", op.Action, "mapping", op.Mapping)
+		cb := provider.AddHostnameMapping
+		if op.Action == OperationRemove {
+			cb = provider.RemoveHostnameMapping
+		}
+		if err := store.applyOutbox(op.Action, op.Mapping, cb); err != nil {
+			errs = append(errs, err, QueueOperation(store, op, time.Now()))
 		}
+	}
+	return errors.Join(errs...)
+}
 
-		record := &types.DNSContainerList{}
-		if err := json.Unmarshal(rawRecord, record); err != nil {
+// applyOutbox executes the callback of a pending outbox entry and removes the entry again
+// If the callback succeeds, the change is applied to the record in the same transaction that removes the entry
+// If it fails, the store is left as it was before the entry was created
+func (store *BoltDBStore) applyOutbox(action string, dnsMapping *types.DNSMapping, cb func(*types.DNSMapping) error) error {
+	cbErr := cb(dnsMapping)
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket([]byte(outboxBucketName)).Delete(getOperationKey(dnsMapping)); err != nil {
 			return err
 		}
-		record.ContainerList = stringslice.RemoveFirst(record.ContainerList, dnsMapping.ContainerID)
-
-		// No mappings anymore, remove from dns provider
-		if len(record.ContainerList) == 0 {
-			if err := removeCB(dnsMapping); err != nil {
-				return err
-			}
-			return bucket.Delete(dnsMapping.GetKey())
+		if cbErr != nil {
+			return nil
 		}
 
-		// Still mappings left, just update store
-		payload, err := json.Marshal(record)
+		bucket := tx.Bucket([]byte(bucketName))
+		record, err := getRecord(bucket, dnsMapping)
 		if err != nil {
 			return err
 		}
-		return bucket.Put(dnsMapping.GetKey(), payload)
+		switch action {
+		case OperationAdd:
+			return putRecord(bucket, dnsMapping.GetKey(), insertRecordMapping(record, dnsMapping))
+		case OperationRemove:
+			if record == nil {
+				return nil
+			}
+			removeRecordContainer(record, dnsMapping.ContainerID)
+			if len(record.ContainerList) == 0 {
+				return bucket.Delete(dnsMapping.GetKey())
+			}
+			return putRecord(bucket, dnsMapping.GetKey(), record)
+		default:
+			return nil
+		}
+	})
+	return errors.Join(cbErr, err)
+}
+
+// getOutbox returns all pending outbox entries
+func (store *BoltDBStore) getOutbox() ([]*Operation, error) {
+	ops := []*Operation{}
+	err := store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(outboxBucketName)).ForEach(func(_, v []byte) error {
+			op := &Operation{}
+			if err := json.Unmarshal(v, op); err != nil {
+				return err
+			}
+			ops = append(ops, op)
+			return nil
+		})
 	})
+	if err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+// putOutboxEntry records that the dns provider is about to be called for the DNSMapping
+func putOutboxEntry(tx *bolt.Tx, action string, dnsMapping *types.DNSMapping) error {
+	payload, err := json.Marshal(&Operation{Action: action, Mapping: dnsMapping})
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(outboxBucketName)).Put(getOperationKey(dnsMapping), payload)
+}
+
+// getRecord returns the record of the DNSMapping in the bucket, or nil if it is not present
+func getRecord(bucket *bolt.Bucket, dnsMapping *types.DNSMapping) (*types.DNSContainerList, error) {
+	rawRecord := bucket.Get(dnsMapping.GetKey())
+	if rawRecord == nil {
+		return nil, nil
+	}
+	return unmarshalRecord(rawRecord)
+}
+
+// putRecord saves the record in the bucket under the given key
+func putRecord(bucket *bolt.Bucket, key []byte, record *types.DNSContainerList) error {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, payload)
+}
+
+// insertRecordMapping adds the ContainerID of the DNSMapping to the record, creating it if it is nil
+// The record takes the settings of the DNSMapping if its ContainerID is the first one in the list
+func insertRecordMapping(record *types.DNSContainerList, dnsMapping *types.DNSMapping) *types.DNSContainerList {
+	if record == nil {
+		return newRecord(dnsMapping)
+	}
+	addRecordContainer(record, dnsMapping)
+	return record
 }
 
 // ReplaceMappings will replace the current list of DNSMappings with the supplied list
 // It will interact with the dns.Provider to ensure the remote state is in sync
-// It will perform a diff with the current state to minimize the amount of API calls to the dns.Provider
+// It will perform a diff with the current state (see DiffMappings) to minimize the amount of API calls to the dns.Provider
+// If the dns.Provider supports batches, all changes are computed from a single snapshot of its records
 func (store *BoltDBStore) ReplaceMappings(mappings []*types.DNSMapping, provider dns.Provider) error {
-	missingItems := []*types.DNSMapping{}
+	defer startBatch(provider)()
+	return replaceMappings(store, mappings, provider)
+}
+
+// GetContainerMappings returns all DNSMappings that are currently registered for the given ContainerID
+func (store *BoltDBStore) GetContainerMappings(containerID string) ([]*types.DNSMapping, error) {
+	var mappings []*types.DNSMapping
 	err := store.db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(bucketName))
-		cursor := bucket.Cursor()
+		var err error
+		mappings, err = store.getContainerMappings(tx.Bucket([]byte(bucketName)), containerID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mappings, nil
+}
 
-		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
-			dnsContainerList := &types.DNSContainerList{}
-			if err := json.Unmarshal(v, dnsContainerList); err != nil {
-				return err
-			}
-			store.logger.Infow("Current Mapping", "mapping", dnsContainerList)
-			for _, containerID := range dnsContainerList.ContainerList {
-				mapping := &types.DNSMapping{
-					Name:        dnsContainerList.Name,
-					IP:          dnsContainerList.IP,
-					ContainerID: containerID,
-				}
-				if types.HasDNSMapping(mappings, mapping) {
-					break
-				}
-				missingItems = append(missingItems, &types.DNSMapping{
-					Name:        dnsContainerList.Name,
-					IP:          dnsContainerList.IP,
-					ContainerID: containerID,
-				})
-			}
+// getContainerMappings returns all DNSMappings of the ContainerID that are present in the bucket
+func (store *BoltDBStore) getContainerMappings(bucket *bolt.Bucket, containerID string) ([]*types.DNSMapping, error) {
+	mappings := []*types.DNSMapping{}
+	err := bucket.ForEach(func(_, v []byte) error {
+		dnsContainerList, err := unmarshalRecord(v)
+		if err != nil {
+			return err
+		}
+		if stringslice.Contains(dnsContainerList.ContainerList, containerID) {
+			mappings = append(mappings, &types.DNSMapping{
+				Name:        dnsContainerList.Name,
+				Type:        dnsContainerList.Type,
+				IP:          dnsContainerList.IP,
+				Settings:    dnsContainerList.GetContainerSettings(containerID),
+				ContainerID: containerID,
+			})
 		}
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+	return mappings, nil
+}
+
+// GetRecords returns all A and AAAA records in the current state, together with the ContainerIDs backing them
+func (store *BoltDBStore) GetRecords() ([]*types.DNSContainerList, error) {
+	records := []*types.DNSContainerList{}
+	err := store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bucketName)).ForEach(func(_, v []byte) error {
+			record, err := unmarshalRecord(v)
+			if err != nil {
+				return err
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// PushOperation adds the operation to the queue, replacing any queued operation of the same DNSMapping
+func (store *BoltDBStore) PushOperation(op *Operation) error {
+	payload, err := json.Marshal(op)
 	if err != nil {
 		return err
 	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(queueBucketName)).Put(getOperationKey(op.Mapping), payload)
+	})
+}
 
-	for i := range mappings {
-		err := store.InsertMapping(mappings[i], provider.AddHostnameMapping)
-		if err != nil {
-			return err
+// DeleteOperation removes the queued operation of the DNSMapping, if there is one
+func (store *BoltDBStore) DeleteOperation(mapping *types.DNSMapping) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(queueBucketName)).Delete(getOperationKey(mapping))
+	})
+}
+
+// DeleteContainerOperations removes all queued operations of the ContainerID
+func (store *BoltDBStore) DeleteContainerOperations(containerID string) error {
+	prefix := []byte(containerID + "\x00")
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(queueBucketName))
+		// Deleting keys while iterating with a cursor skips elements, so collect them first
+		keys := [][]byte{}
+		cursor := bucket.Cursor()
+		for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+			keys = append(keys, append([]byte{}, k...))
 		}
-	}
+		for _, key := range keys {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
 
-	for i := range missingItems {
-		err := store.RemoveMapping(missingItems[i], provider.RemoveHostnameMapping)
-		if err != nil {
+// ClearOperations removes all queued operations
+func (store *BoltDBStore) ClearOperations() error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket([]byte(queueBucketName)); err != nil {
 			return err
 		}
+		_, err := tx.CreateBucket([]byte(queueBucketName))
+		return err
+	})
+}
+
+// GetOperations returns all queued operations, ordered by their ContainerID and DNSMapping
+func (store *BoltDBStore) GetOperations() ([]*Operation, error) {
+	ops := []*Operation{}
+	err := store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(queueBucketName)).ForEach(func(_, v []byte) error {
+			op := &Operation{}
+			if err := json.Unmarshal(v, op); err != nil {
+				return err
+			}
+			ops = append(ops, op)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
 	}
+	return ops, nil
+}
 
-	return nil
+// unmarshalRecord parses a DNSContainerList that was persisted in boltdb
+// Records that were persisted before the record type was stored get the type that matches their IP
+func unmarshalRecord(rawRecord []byte) (*types.DNSContainerList, error) {
+	record := &types.DNSContainerList{}
+	if err := json.Unmarshal(rawRecord, record); err != nil {
+		return nil, err
+	}
+	if record.Type == "" {
+		record.Type = types.GetRecordType(record.IP)
+	}
+	return record, nil
 }
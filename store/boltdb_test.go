@@ -0,0 +1,102 @@
+package store
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+	"github.com/wdullaer/dd-dns/types"
+	"go.uber.org/zap"
+)
+
+func TestBoltDBStoreOutbox(t *testing.T) {
+	store, err := NewBoltDBStore(zap.NewNop().Sugar(), t.TempDir())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer store.CleanUp()
+	mapping := types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "foo")
+
+	t.Run("Should call the provider outside of the write transaction", func(t *testing.T) {
+		cb := func(m *types.DNSMapping) error {
+			// The change is pending in the outbox while the provider is called
+			ops, err := store.getOutbox()
+			assert.NoError(t, err)
+			assert.Equal(t, []*Operation{{Action: OperationAdd, Mapping: m}}, ops)
+
+			// Other writes must not block on the provider call
+			done := make(chan error, 1)
+			go func() { done <- store.PushOperation(&Operation{Action: OperationAdd, Mapping: m}) }()
+			select {
+			case err := <-done:
+				return err
+			case <-time.After(time.Second):
+				return errors.New("write blocked by the provider call")
+			}
+		}
+		assert.NoError(t, store.InsertMapping(mapping, cb))
+		assert.NoError(t, store.ClearOperations())
+
+		ops, err := store.getOutbox()
+		assert.NoError(t, err)
+		assert.Empty(t, ops)
+		mappings, err := store.GetContainerMappings("foo")
+		assert.NoError(t, err)
+		assert.Equal(t, []*types.DNSMapping{mapping}, mappings)
+	})
+
+	t.Run("Should leave the store unchanged if the provider call fails", func(t *testing.T) {
+		provider := &outageProvider{recordingProvider: &recordingProvider{DryrunProvider: newTestProvider(t)}, down: true}
+		assert.Error(t, store.RemoveMapping(mapping, provider))
+
+		ops, err := store.getOutbox()
+		assert.NoError(t, err)
+		assert.Empty(t, ops)
+		mappings, err := store.GetContainerMappings("foo")
+		assert.NoError(t, err)
+		assert.Equal(t, []*types.DNSMapping{mapping}, mappings)
+	})
+}
+
+func TestBoltDBStoreRecoverOutbox(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	dataDir := t.TempDir()
+	foo := types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "foo")
+	bar := types.NewDNSMapping("bar.example.com", net.ParseIP("192.168.0.1"), "bar")
+
+	store, err := NewBoltDBStore(logger, dataDir)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, store.InsertMapping(bar, func(*types.DNSMapping) error { return nil }))
+	// Simulate a crash while the provider was being called
+	assert.NoError(t, store.db.Update(func(tx *bolt.Tx) error {
+		if err := putOutboxEntry(tx, OperationAdd, foo); err != nil {
+			return err
+		}
+		return putOutboxEntry(tx, OperationRemove, bar)
+	}))
+	store.CleanUp()
+
+	store, err = NewBoltDBStore(logger, dataDir)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer store.CleanUp()
+	provider := newTestProvider(t)
+	provider.Zone = map[string][]net.IP{"bar.example.com": {net.ParseIP("192.168.0.1")}}
+	assert.NoError(t, store.RecoverOutbox(provider))
+
+	assert.Equal(t, map[string][]net.IP{"foo.example.com": {net.ParseIP("192.168.0.1")}}, provider.Zone)
+	records, err := store.GetRecords()
+	assert.NoError(t, err)
+	assert.Equal(t, []*types.DNSContainerList{
+		{Name: "foo.example.com", Type: types.RecordTypeA, IP: net.ParseIP("192.168.0.1"), ContainerList: []string{"foo"}},
+	}, records)
+	ops, err := store.getOutbox()
+	assert.NoError(t, err)
+	assert.Empty(t, ops)
+}
@@ -0,0 +1,100 @@
+package store
+
+import (
+	"github.com/wdullaer/dd-dns/dns"
+	"github.com/wdullaer/dd-dns/types"
+)
+
+// MappingDiff holds the changes that turn a current list of DNSMappings into a desired one
+// A DNSMapping is identified by its ContainerID, hostname and IP, its settings are compared separately
+type MappingDiff struct {
+	// Added are the desired mappings that are not present in the current list
+	Added []*types.DNSMapping
+	// Updated are the desired mappings that are present in the current list with different settings
+	Updated []*types.DNSMapping
+	// Removed are the current mappings that are not present in the desired list
+	Removed []*types.DNSMapping
+}
+
+// DiffMappings computes the exact set of changes between the current and the desired DNSMappings
+// Duplicates are ignored, and the order of each input is preserved in the output
+func DiffMappings(desired []*types.DNSMapping, current []*types.DNSMapping) *MappingDiff {
+	currentByKey := make(map[string]*types.DNSMapping, len(current))
+	for _, mapping := range current {
+		currentByKey[string(getOperationKey(mapping))] = mapping
+	}
+
+	diff := &MappingDiff{Added: []*types.DNSMapping{}, Updated: []*types.DNSMapping{}, Removed: []*types.DNSMapping{}}
+	desiredKeys := make(map[string]bool, len(desired))
+	for _, mapping := range desired {
+		key := string(getOperationKey(mapping))
+		if desiredKeys[key] {
+			continue
+		}
+		desiredKeys[key] = true
+		if currentMapping, ok := currentByKey[key]; !ok {
+			diff.Added = append(diff.Added, mapping)
+		} else if currentMapping.Settings != mapping.Settings {
+			diff.Updated = append(diff.Updated, mapping)
+		}
+	}
+
+	for _, mapping := range current {
+		key := string(getOperationKey(mapping))
+		if !desiredKeys[key] {
+			desiredKeys[key] = true
+			diff.Removed = append(diff.Removed, mapping)
+		}
+	}
+	return diff
+}
+
+// IsEmpty returns true if the current and desired mappings are equal
+func (diff *MappingDiff) IsEmpty() bool {
+	return len(diff.Added) == 0 && len(diff.Updated) == 0 && len(diff.Removed) == 0
+}
+
+// getRecordMappings returns a DNSMapping for every ContainerID of every record
+func getRecordMappings(records []*types.DNSContainerList) []*types.DNSMapping {
+	mappings := []*types.DNSMapping{}
+	for _, record := range records {
+		for _, containerID := range record.ContainerList {
+			mappings = append(mappings, &types.DNSMapping{
+				Name:        record.Name,
+				Type:        record.Type,
+				IP:          record.IP,
+				Settings:    record.GetContainerSettings(containerID),
+				ContainerID: containerID,
+			})
+		}
+	}
+	return mappings
+}
+
+// applyDiff applies the changes to the store, which calls the dns.Provider where needed
+// Mappings are added and updated before any is removed, so a hostname that moves to a new IP always resolves
+func applyDiff(store Store, diff *MappingDiff, provider dns.Provider) error {
+	for _, mappings := range [][]*types.DNSMapping{diff.Added, diff.Updated} {
+		for i := range mappings {
+			if err := store.InsertMapping(mappings[i], provider.AddHostnameMapping); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := range diff.Removed {
+		if err := store.RemoveMapping(diff.Removed[i], provider); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceMappings replaces all mappings of the store with the supplied list, using the minimal set of changes
+func replaceMappings(store Store, mappings []*types.DNSMapping, provider dns.Provider) error {
+	records, err := store.GetRecords()
+	if err != nil {
+		return err
+	}
+	return applyDiff(store, DiffMappings(mappings, getRecordMappings(records)), provider)
+}
@@ -0,0 +1,170 @@
+package store
+
+import (
+	"math/rand"
+	"net"
+	"reflect"
+	"testing"
+	"testing/quick"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wdullaer/dd-dns/types"
+)
+
+// mappingList is a list of DNSMappings drawn from a small domain, so the generated lists overlap
+type mappingList []*types.DNSMapping
+
+// Generate implements quick.Generator
+func (mappingList) Generate(rand *rand.Rand, size int) reflect.Value {
+	names := []string{"a.example.com", "b.example.com"}
+	ips := []net.IP{net.ParseIP("192.168.0.1"), net.ParseIP("192.168.0.2"), net.ParseIP("2001:db8::1")}
+	containers := []string{"foo", "bar", "baz"}
+
+	mappings := mappingList{}
+	for i := rand.Intn(size + 1); i > 0; i-- {
+		mapping := types.NewDNSMapping(names[rand.Intn(len(names))], ips[rand.Intn(len(ips))], containers[rand.Intn(len(containers))])
+		mapping.Settings.TTL = []int{0, 120}[rand.Intn(2)]
+		mappings = append(mappings, mapping)
+	}
+	return reflect.ValueOf(mappings)
+}
+
+// getMappingKeys returns the set of identities of the mappings
+func getMappingKeys(mappings []*types.DNSMapping) map[string]bool {
+	keys := map[string]bool{}
+	for _, mapping := range mappings {
+		keys[string(getOperationKey(mapping))] = true
+	}
+	return keys
+}
+
+func TestDiffMappings(t *testing.T) {
+	foo := types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "foo")
+	bar := types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "bar")
+	baz := types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "baz")
+	fooTTL := &types.DNSMapping{Name: foo.Name, Type: foo.Type, IP: foo.IP, ContainerID: foo.ContainerID, Settings: types.RecordSettings{TTL: 120}}
+
+	cases := []struct {
+		name     string
+		desired  []*types.DNSMapping
+		current  []*types.DNSMapping
+		expected *MappingDiff
+	}{
+		{
+			name:     "Should return an empty diff for equal lists",
+			desired:  []*types.DNSMapping{foo, bar},
+			current:  []*types.DNSMapping{bar, foo},
+			expected: &MappingDiff{Added: []*types.DNSMapping{}, Updated: []*types.DNSMapping{}, Removed: []*types.DNSMapping{}},
+		},
+		{
+			name:     "Should remove every stale container of a record that is still desired",
+			desired:  []*types.DNSMapping{foo},
+			current:  []*types.DNSMapping{foo, bar, baz},
+			expected: &MappingDiff{Added: []*types.DNSMapping{}, Updated: []*types.DNSMapping{}, Removed: []*types.DNSMapping{bar, baz}},
+		},
+		{
+			name:     "Should add the containers that are missing",
+			desired:  []*types.DNSMapping{foo, bar, bar},
+			current:  []*types.DNSMapping{foo},
+			expected: &MappingDiff{Added: []*types.DNSMapping{bar}, Updated: []*types.DNSMapping{}, Removed: []*types.DNSMapping{}},
+		},
+		{
+			name:     "Should update mappings whose settings changed",
+			desired:  []*types.DNSMapping{fooTTL},
+			current:  []*types.DNSMapping{foo},
+			expected: &MappingDiff{Added: []*types.DNSMapping{}, Updated: []*types.DNSMapping{fooTTL}, Removed: []*types.DNSMapping{}},
+		},
+		{
+			name:     "Should remove everything if nothing is desired",
+			desired:  nil,
+			current:  []*types.DNSMapping{foo, bar},
+			expected: &MappingDiff{Added: []*types.DNSMapping{}, Updated: []*types.DNSMapping{}, Removed: []*types.DNSMapping{foo, bar}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, DiffMappings(tc.desired, tc.current))
+		})
+	}
+}
+
+func TestDiffMappingsProperties(t *testing.T) {
+	t.Run("Should turn the current mappings into the desired ones", func(t *testing.T) {
+		property := func(desired mappingList, current mappingList) bool {
+			diff := DiffMappings(desired, current)
+			result := getMappingKeys(current)
+			for _, mapping := range diff.Removed {
+				delete(result, string(getOperationKey(mapping)))
+			}
+			for key := range getMappingKeys(diff.Added) {
+				result[key] = true
+			}
+			return reflect.DeepEqual(result, getMappingKeys(desired))
+		}
+		assert.NoError(t, quick.Check(property, nil))
+	})
+
+	t.Run("Should only add missing mappings and only remove present ones", func(t *testing.T) {
+		property := func(desired mappingList, current mappingList) bool {
+			diff := DiffMappings(desired, current)
+			currentKeys := getMappingKeys(current)
+			desiredKeys := getMappingKeys(desired)
+			for _, mapping := range diff.Added {
+				if currentKeys[string(getOperationKey(mapping))] {
+					return false
+				}
+			}
+			for _, mapping := range diff.Updated {
+				if !currentKeys[string(getOperationKey(mapping))] {
+					return false
+				}
+			}
+			for _, mapping := range diff.Removed {
+				if desiredKeys[string(getOperationKey(mapping))] {
+					return false
+				}
+			}
+			return len(getMappingKeys(diff.Removed)) == len(diff.Removed)
+		}
+		assert.NoError(t, quick.Check(property, nil))
+	})
+
+	t.Run("Should return an empty diff for the same list", func(t *testing.T) {
+		property := func(mappings mappingList) bool {
+			unique := DiffMappings(mappings, nil).Added
+			return DiffMappings(unique, unique).IsEmpty()
+		}
+		assert.NoError(t, quick.Check(property, nil))
+	})
+}
+
+func TestStoreReplaceMappingsProperties(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			provider := newTestProvider(t)
+			property := func(desired mappingList) bool {
+				if err := store.ReplaceMappings(desired, provider); err != nil {
+					return false
+				}
+				records, err := store.GetRecords()
+				if err != nil {
+					return false
+				}
+				zone := map[string]bool{}
+				for name, ips := range provider.Zone {
+					for _, ip := range ips {
+						zone[name+" "+ip.String()] = true
+					}
+				}
+				expectedZone := map[string]bool{}
+				for _, mapping := range desired {
+					expectedZone[mapping.Name+" "+mapping.IP.String()] = true
+				}
+				return reflect.DeepEqual(getMappingKeys(getRecordMappings(records)), getMappingKeys(desired)) &&
+					reflect.DeepEqual(zone, expectedZone)
+			}
+			assert.NoError(t, quick.Check(property, nil))
+		})
+	}
+}
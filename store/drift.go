@@ -0,0 +1,122 @@
+package store
+
+import (
+	"errors"
+
+	"github.com/wdullaer/dd-dns/dns"
+	"github.com/wdullaer/dd-dns/types"
+)
+
+// DriftReport lists the changes that were made at the dns.Provider to bring it back in line with the Store
+// Updated lists the records whose settings were changed at the provider
+// Unmanaged lists the unknown records of managed hostnames that were left alone,
+// because the provider could not prove that dd-dns created them
+// Skipped lists the records that were not repaired, because they are owned by someone else (see dns.OwnershipProvider)
+type DriftReport struct {
+	Created   []*types.DNSMapping
+	Updated   []*types.DNSMapping
+	Removed   []*types.DNSMapping
+	Unmanaged []*types.DNSMapping
+	Skipped   []*types.DNSMapping
+}
+
+// HasChanges returns true if any record had to be repaired
+func (report *DriftReport) HasChanges() bool {
+	return len(report.Created) != 0 || len(report.Updated) != 0 || len(report.Removed) != 0
+}
+
+// RepairDrift compares the records at the dns.Provider with the current state of the Store
+// Records that are missing at the provider (eg: because they were deleted or edited by hand) are created again
+// Records whose settings were changed at the provider are updated, if the provider reports them (see dns.SettingsProvider)
+// A or AAAA records of a managed hostname that are not present in the Store are only removed if the provider
+// can prove that dd-dns created them (see dns.ManagedRecordProvider), otherwise they are only reported
+// This protects records that were added by hand, or by another instance of dd-dns that shares the hostname
+// Hostnames that are not present in the Store are never touched
+// A failure to repair a single record does not stop the repair of the others, all errors are returned together
+func RepairDrift(store Store, provider dns.Provider) (*DriftReport, error) {
+	defer startBatch(provider)()
+
+	records, err := store.GetRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	hostnames := []string{}
+	seen := map[string]bool{}
+	desired := map[string]bool{}
+	for _, record := range records {
+		if !seen[record.Name] {
+			seen[record.Name] = true
+			hostnames = append(hostnames, record.Name)
+		}
+		desired[getDriftKey(record.Name, record.Type, record.IP.String())] = true
+	}
+
+	actualMappings, err := provider.ListHostnameMappings(hostnames)
+	if err != nil {
+		return nil, err
+	}
+	actual := map[string]*types.DNSMapping{}
+	for _, mapping := range actualMappings {
+		actual[getDriftKey(mapping.Name, mapping.Type, mapping.IP.String())] = mapping
+	}
+	settingsProvider, hasSettings := provider.(dns.SettingsProvider)
+	ownershipProvider, hasOwnership := provider.(dns.OwnershipProvider)
+
+	report := &DriftReport{Created: []*types.DNSMapping{}, Updated: []*types.DNSMapping{}, Removed: []*types.DNSMapping{}, Unmanaged: []*types.DNSMapping{}, Skipped: []*types.DNSMapping{}}
+	errs := []error{}
+	for _, record := range records {
+		current := actual[getDriftKey(record.Name, record.Type, record.IP.String())]
+		if current != nil && (!hasSettings || settingsProvider.HasSettings(current, record.Settings)) {
+			continue
+		}
+		mapping := &types.DNSMapping{Name: record.Name, Type: record.Type, IP: record.IP, Settings: record.Settings}
+		if len(record.ContainerList) != 0 {
+			mapping.ContainerID = record.ContainerList[0]
+		}
+		// AddHostnameMapping updates the settings of a record that already exists
+		added, err := addHostnameMapping(provider, ownershipProvider, hasOwnership, mapping)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !added {
+			report.Skipped = append(report.Skipped, mapping)
+		} else if current != nil {
+			report.Updated = append(report.Updated, mapping)
+		} else {
+			report.Created = append(report.Created, mapping)
+		}
+	}
+
+	for _, mapping := range actualMappings {
+		if desired[getDriftKey(mapping.Name, mapping.Type, mapping.IP.String())] {
+			continue
+		}
+		if managedProvider, ok := provider.(dns.ManagedRecordProvider); !ok || !managedProvider.IsManagedRecord(mapping) {
+			report.Unmanaged = append(report.Unmanaged, mapping)
+			continue
+		}
+		if err := provider.RemoveHostnameMapping(mapping); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		report.Removed = append(report.Removed, mapping)
+	}
+
+	return report, errors.Join(errs...)
+}
+
+// addHostnameMapping adds the record at the provider
+// Returns false if the provider skipped the record, because it is owned by someone else
+func addHostnameMapping(provider dns.Provider, ownershipProvider dns.OwnershipProvider, hasOwnership bool, mapping *types.DNSMapping) (bool, error) {
+	if hasOwnership {
+		return ownershipProvider.AddOwnedHostnameMapping(mapping)
+	}
+	return true, provider.AddHostnameMapping(mapping)
+}
+
+// getDriftKey returns a key that uniquely identifies an A or AAAA record
+func getDriftKey(name string, recordType string, ip string) string {
+	return name + "\x00" + recordType + "\x00" + ip
+}
@@ -0,0 +1,213 @@
+package store
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wdullaer/dd-dns/dns"
+	"github.com/wdullaer/dd-dns/types"
+	"go.uber.org/zap"
+)
+
+// managedProvider is a DryrunProvider that considers every record to be created by dd-dns
+type managedProvider struct {
+	*dns.DryrunProvider
+}
+
+func (*managedProvider) IsManagedRecord(*types.DNSMapping) bool {
+	return true
+}
+
+// settingsProvider is a DryrunProvider that also keeps track of the settings of its records
+type settingsProvider struct {
+	*dns.DryrunProvider
+	settings map[string]types.RecordSettings
+}
+
+func (provider *settingsProvider) AddHostnameMapping(mapping *types.DNSMapping) error {
+	provider.settings[mapping.Name+" "+mapping.IP.String()] = mapping.Settings
+	return provider.DryrunProvider.AddHostnameMapping(mapping)
+}
+
+func (provider *settingsProvider) ListHostnameMappings(hostnames []string) ([]*types.DNSMapping, error) {
+	mappings, err := provider.DryrunProvider.ListHostnameMappings(hostnames)
+	for _, mapping := range mappings {
+		mapping.Settings = provider.settings[mapping.Name+" "+mapping.IP.String()]
+	}
+	return mappings, err
+}
+
+func (*settingsProvider) HasSettings(mapping *types.DNSMapping, settings types.RecordSettings) bool {
+	return mapping.Settings == settings
+}
+
+func TestRepairDriftSettings(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	store, err := NewMemoryStore(logger)
+	if !assert.NoError(t, err) {
+		return
+	}
+	dryrunProvider, err := dns.NewDryrunProvider(logger)
+	if !assert.NoError(t, err) {
+		return
+	}
+	provider := &settingsProvider{DryrunProvider: dryrunProvider, settings: map[string]types.RecordSettings{}}
+
+	foo := &types.DNSMapping{Name: "foo.example.com", Type: types.RecordTypeA, IP: net.ParseIP("192.168.0.1"), ContainerID: "foo", Settings: types.RecordSettings{TTL: 120}}
+	bar := &types.DNSMapping{Name: "bar.example.com", Type: types.RecordTypeA, IP: net.ParseIP("192.168.0.1"), ContainerID: "bar", Settings: types.RecordSettings{TTL: 120}}
+	for _, mapping := range []*types.DNSMapping{foo, bar} {
+		if !assert.NoError(t, store.InsertMapping(mapping, provider.AddHostnameMapping)) {
+			return
+		}
+	}
+	// Edit the TTL of one of the records by hand
+	provider.settings["foo.example.com 192.168.0.1"] = types.RecordSettings{TTL: 3600}
+
+	report, err := RepairDrift(store, provider)
+	assert.NoError(t, err)
+	assert.Equal(t, &DriftReport{
+		Created:   []*types.DNSMapping{},
+		Updated:   []*types.DNSMapping{foo},
+		Removed:   []*types.DNSMapping{},
+		Unmanaged: []*types.DNSMapping{},
+		Skipped:   []*types.DNSMapping{},
+	}, report)
+	assert.True(t, report.HasChanges())
+	assert.Equal(t, types.RecordSettings{TTL: 120}, provider.settings["foo.example.com 192.168.0.1"])
+}
+
+func TestRepairDrift(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    []*types.DNSMapping
+		zone     map[string][]net.IP
+		managed  bool
+		expected map[string][]net.IP
+		report   *DriftReport
+	}{
+		{
+			name:     "Should not change anything if the provider is in sync",
+			input:    []*types.DNSMapping{types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "foo")},
+			zone:     map[string][]net.IP{"foo.example.com": {net.ParseIP("192.168.0.1")}},
+			expected: map[string][]net.IP{"foo.example.com": {net.ParseIP("192.168.0.1")}},
+			report:   &DriftReport{Created: []*types.DNSMapping{}, Updated: []*types.DNSMapping{}, Removed: []*types.DNSMapping{}, Unmanaged: []*types.DNSMapping{}, Skipped: []*types.DNSMapping{}},
+		},
+		{
+			name: "Should re-create records that were deleted at the provider",
+			input: []*types.DNSMapping{
+				types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "foo"),
+				types.NewDNSMapping("foo.example.com", net.ParseIP("2001:db8::1"), "foo"),
+			},
+			zone:     map[string][]net.IP{"foo.example.com": {net.ParseIP("192.168.0.1")}},
+			expected: map[string][]net.IP{"foo.example.com": {net.ParseIP("192.168.0.1"), net.ParseIP("2001:db8::1")}},
+			report: &DriftReport{
+				Created:   []*types.DNSMapping{types.NewDNSMapping("foo.example.com", net.ParseIP("2001:db8::1"), "foo")},
+				Updated:   []*types.DNSMapping{},
+				Removed:   []*types.DNSMapping{},
+				Unmanaged: []*types.DNSMapping{},
+				Skipped:   []*types.DNSMapping{},
+			},
+		},
+		{
+			name:     "Should repair records that were edited at the provider",
+			input:    []*types.DNSMapping{types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "foo")},
+			zone:     map[string][]net.IP{"foo.example.com": {net.ParseIP("192.168.0.2")}},
+			managed:  true,
+			expected: map[string][]net.IP{"foo.example.com": {net.ParseIP("192.168.0.1")}},
+			report: &DriftReport{
+				Created:   []*types.DNSMapping{types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "foo")},
+				Updated:   []*types.DNSMapping{},
+				Removed:   []*types.DNSMapping{types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.2"), "")},
+				Unmanaged: []*types.DNSMapping{},
+				Skipped:   []*types.DNSMapping{},
+			},
+		},
+		{
+			name:     "Should not remove a foreign record of a managed hostname",
+			input:    []*types.DNSMapping{types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "foo")},
+			zone:     map[string][]net.IP{"foo.example.com": {net.ParseIP("192.168.0.1"), net.ParseIP("192.168.0.2")}},
+			expected: map[string][]net.IP{"foo.example.com": {net.ParseIP("192.168.0.1"), net.ParseIP("192.168.0.2")}},
+			report: &DriftReport{
+				Created:   []*types.DNSMapping{},
+				Updated:   []*types.DNSMapping{},
+				Removed:   []*types.DNSMapping{},
+				Unmanaged: []*types.DNSMapping{types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.2"), "")},
+				Skipped:   []*types.DNSMapping{},
+			},
+		},
+		{
+			name:  "Should not touch hostnames that are not in the store",
+			input: []*types.DNSMapping{types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "foo")},
+			zone: map[string][]net.IP{
+				"foo.example.com": {net.ParseIP("192.168.0.1")},
+				"bar.example.com": {net.ParseIP("192.168.0.2")},
+			},
+			expected: map[string][]net.IP{
+				"foo.example.com": {net.ParseIP("192.168.0.1")},
+				"bar.example.com": {net.ParseIP("192.168.0.2")},
+			},
+			report: &DriftReport{Created: []*types.DNSMapping{}, Updated: []*types.DNSMapping{}, Removed: []*types.DNSMapping{}, Unmanaged: []*types.DNSMapping{}, Skipped: []*types.DNSMapping{}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := zap.NewNop().Sugar()
+			store, err := NewMemoryStore(logger)
+			if !assert.NoError(t, err) {
+				return
+			}
+			provider, err := dns.NewDryrunProvider(logger)
+			if !assert.NoError(t, err) {
+				return
+			}
+			for _, mapping := range tc.input {
+				if !assert.NoError(t, store.InsertMapping(mapping, func(*types.DNSMapping) error { return nil })) {
+					return
+				}
+			}
+			provider.Zone = tc.zone
+
+			var target dns.Provider = provider
+			if tc.managed {
+				target = &managedProvider{provider}
+			}
+			report, err := RepairDrift(store, target)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.report, report)
+			assert.Equal(t, tc.expected, provider.Zone)
+		})
+	}
+}
+
+func TestRepairDriftOwnership(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	store, err := NewMemoryStore(logger)
+	if !assert.NoError(t, err) {
+		return
+	}
+	provider, err := dns.NewDryrunProvider(logger)
+	if !assert.NoError(t, err) {
+		return
+	}
+	registry, err := dns.NewOwnershipRegistry(provider, "host-1", logger)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// foo.example.com is owned by another instance, so the registry does not list it and can't re-create it
+	foo := types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "foo")
+	provider.Zone = map[string][]net.IP{"foo.example.com": {net.ParseIP("192.168.0.1")}}
+	provider.TXT = map[string][]string{"foo.example.com": {"heritage=dd-dns,dd-dns/owner=host-2,dd-dns/record=A/192.168.0.1"}}
+	if !assert.NoError(t, store.InsertMapping(foo, func(*types.DNSMapping) error { return nil })) {
+		return
+	}
+
+	for range 2 {
+		report, err := RepairDrift(store, registry)
+		assert.NoError(t, err)
+		assert.False(t, report.HasChanges())
+		assert.Equal(t, []*types.DNSMapping{{Name: foo.Name, Type: foo.Type, IP: foo.IP, ContainerID: "foo"}}, report.Skipped)
+	}
+}
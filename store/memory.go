@@ -1,9 +1,14 @@
 package store
 
 import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+
 	memdb "github.com/hashicorp/go-memdb"
 	"github.com/wdullaer/dd-dns/dns"
-	"github.com/wdullaer/dd-dns/stringslice"
 	"github.com/wdullaer/dd-dns/types"
 	"go.uber.org/zap"
 )
@@ -12,8 +17,10 @@ const tableName = "dns-mapping"
 
 // MemoryStore implements the Store interface using an ephemeral in memory database
 type MemoryStore struct {
-	db     *memdb.MemDB
-	logger *zap.SugaredLogger
+	db         *memdb.MemDB
+	queueMutex sync.Mutex
+	queue      map[string]*Operation
+	logger     *zap.SugaredLogger
 }
 
 // NewMemoryStore returns a new instance of a MemoryStore
@@ -26,12 +33,12 @@ func NewMemoryStore(logger *zap.SugaredLogger) (*MemoryStore, error) {
 					"id": &memdb.IndexSchema{
 						Name:    "id",
 						Unique:  true,
-						Indexer: &memdb.CompoundIndex{Indexes: []memdb.Indexer{&memdb.StringFieldIndex{Field: "Name"}, &memdb.StringFieldIndex{Field: "IP"}}},
+						Indexer: &memdb.CompoundIndex{Indexes: []memdb.Indexer{&memdb.StringFieldIndex{Field: "Name"}, &ipFieldIndex{}}},
 					},
 					"containerid": &memdb.IndexSchema{
 						Name:    "containerid",
-						Unique:  true,
-						Indexer: &memdb.StringSliceFieldIndex{Field: "ContainerID"},
+						Unique:  false,
+						Indexer: &memdb.StringSliceFieldIndex{Field: "ContainerList"},
 					},
 				},
 			},
@@ -42,70 +49,115 @@ func NewMemoryStore(logger *zap.SugaredLogger) (*MemoryStore, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &MemoryStore{db: db, logger: logger.Named("memory-store")}, nil
+	return &MemoryStore{db: db, queue: map[string]*Operation{}, logger: logger.Named("memory-store")}, nil
 }
 
 // CleanUp is a no-op for the MemoryStore
 func (*MemoryStore) CleanUp() {}
 
-// InsertMapping registers that the ContainerID of the DNSMapping supports an A record
-// In case the A record is not present in the current state, the callback will be executed
-// which should create it at the DNSProvider
+// RecoverOutbox is a no-op for the MemoryStore, since it does not survive a crash
+func (*MemoryStore) RecoverOutbox(dns.Provider) error {
+	return nil
+}
+
+// InsertMapping registers that the ContainerID of the DNSMapping supports an A or AAAA record
+// In case the record is not present in the current state, or its settings changed, the callback will be executed
+// which should create or update it at the DNSProvider
 // TODO: maybe pass a dns.Provider, rather than a generic callback
 func (store *MemoryStore) InsertMapping(mapping *types.DNSMapping, cb func(*types.DNSMapping) error) error {
 	txn := store.db.Txn(true)
 	defer txn.Abort()
 
-	rawRecord, err := txn.First(tableName, "id", mapping.Name, mapping.IP.String())
+	if err := store.insertMapping(txn, mapping, cb); err != nil {
+		return err
+	}
+
+	txn.Commit()
+	return nil
+}
+
+// RemoveMapping removes the ContainerID from the list backing the A or AAAA record
+// In case this was the last ContainerID in the list, the record is removed from the dns.Provider
+// Otherwise, if the record has to take the settings of its new first ContainerID, it is updated at the dns.Provider
+func (store *MemoryStore) RemoveMapping(mapping *types.DNSMapping, provider dns.Provider) error {
+	txn := store.db.Txn(true)
+	defer txn.Abort()
+
+	if err := store.removeMapping(txn, mapping, provider); err != nil {
+		return err
+	}
+
+	txn.Commit()
+	return nil
+}
+
+// UpdateContainerMappings replaces the DNSMappings of a single container with the supplied list in one transaction
+// New mappings are inserted before the old ones are removed, so a hostname that moves to a new IP always resolves
+func (store *MemoryStore) UpdateContainerMappings(containerID string, mappings []*types.DNSMapping, provider dns.Provider) error {
+	txn := store.db.Txn(true)
+	defer txn.Abort()
+
+	currentMappings, err := store.getContainerMappings(txn, containerID)
 	if err != nil {
 		return err
 	}
 
-	if rawRecord == nil {
-		if err = cb(mapping); err != nil {
-			return err
+	diff := DiffMappings(mappings, currentMappings)
+	for _, upserts := range [][]*types.DNSMapping{diff.Added, diff.Updated} {
+		for i := range upserts {
+			if err := store.insertMapping(txn, upserts[i], provider.AddHostnameMapping); err != nil {
+				return err
+			}
 		}
-		err = txn.Insert(tableName, &types.DNSContainerList{
-			Name:          mapping.Name,
-			IP:            mapping.IP,
-			ContainerList: []string{mapping.ContainerID},
-		})
-		if err != nil {
+	}
+
+	for i := range diff.Removed {
+		if err := store.removeMapping(txn, diff.Removed[i], provider); err != nil {
 			return err
 		}
-		txn.Commit()
-		return nil
 	}
 
-	record := rawRecord.(*types.DNSContainerList)
+	txn.Commit()
+	return nil
+}
 
-	if !stringslice.Contains(record.ContainerList, mapping.ContainerID) {
-		if err = txn.Delete(tableName, record); err != nil {
+// insertMapping adds the ContainerID of the DNSMapping to its record as part of the transaction
+func (store *MemoryStore) insertMapping(txn *memdb.Txn, mapping *types.DNSMapping, cb func(*types.DNSMapping) error) error {
+	rawRecord, err := txn.First(tableName, "id", mapping.Name, mapping.IP.String())
+	if err != nil {
+		return err
+	}
+
+	if rawRecord == nil {
+		if err = cb(mapping); err != nil {
 			return err
 		}
-		record.ContainerList = append(record.ContainerList, mapping.ContainerID)
-		if err = txn.Insert(tableName, record); err != nil {
+		return txn.Insert(tableName, newRecord(mapping))
+	}
+
+	current := rawRecord.(*types.DNSContainerList)
+	record := copyRecord(current)
+	addRecordContainer(record, mapping)
+	if record.Settings != current.Settings {
+		if err = cb(mapping); err != nil {
 			return err
 		}
 	}
 
-	txn.Commit()
-	return nil
+	if err = txn.Delete(tableName, rawRecord); err != nil {
+		return err
+	}
+	return txn.Insert(tableName, record)
 }
 
-// RemoveMapping removes the ContainerID from the list backing the A record
-// In case this was the last ContainerID in the list, the callback will be executed
-// to remove the A record from the DNSProvider
-func (store *MemoryStore) RemoveMapping(mapping *types.DNSMapping, cb func(*types.DNSMapping) error) error {
-	txn := store.db.Txn(true)
-	defer txn.Abort()
-
-	rawRecord, err := txn.First(tableName, "containerid", mapping.ContainerID)
+// removeMapping removes the ContainerID of the DNSMapping from its record as part of the transaction
+func (store *MemoryStore) removeMapping(txn *memdb.Txn, mapping *types.DNSMapping, provider dns.Provider) error {
+	rawRecord, err := txn.First(tableName, "id", mapping.Name, mapping.IP.String())
 	if err != nil {
 		return err
 	}
 	if rawRecord == nil {
-		store.logger.Warnw("Trying to remove non-existing DNS-container mapping. (containerID: %s)", "containerID", mapping.ContainerID)
+		store.logger.Warnw("Trying to remove non-existing DNS-container mapping", "mapping", mapping)
 		return nil
 	}
 
@@ -113,68 +165,159 @@ func (store *MemoryStore) RemoveMapping(mapping *types.DNSMapping, cb func(*type
 		return err
 	}
 
-	record := rawRecord.(*types.DNSContainerList)
-	record.ContainerList = stringslice.RemoveFirst(record.ContainerList, mapping.ContainerID)
+	record := copyRecord(rawRecord.(*types.DNSContainerList))
+	removeRecordContainer(record, mapping.ContainerID)
 
 	if len(record.ContainerList) == 0 {
-		if err = cb(mapping); err != nil {
-			return err
-		}
-	} else {
-		if err = txn.Insert(tableName, record); err != nil {
+		return provider.RemoveHostnameMapping(mapping)
+	}
+	if update := getSettingsUpdate(record); update != nil {
+		if err = provider.AddHostnameMapping(update); err != nil {
 			return err
 		}
+		setRecordSettings(record, update.Settings)
 	}
-
-	txn.Commit()
-	return nil
+	return txn.Insert(tableName, record)
 }
 
 // ReplaceMappings will replace the current list of DNSMappings with the supplied list
 // It will interact with the dns.Provider to ensure the remote state is in sync
-// It will perform a diff with the current state to minimize the amount of API calls to the dns.Provider
+// It will perform a diff with the current state (see DiffMappings) to minimize the amount of API calls to the dns.Provider
+// If the dns.Provider supports batches, all changes are computed from a single snapshot of its records
 func (store *MemoryStore) ReplaceMappings(mappings []*types.DNSMapping, provider dns.Provider) error {
+	defer startBatch(provider)()
+	return replaceMappings(store, mappings, provider)
+}
+
+// GetContainerMappings returns all DNSMappings that are currently registered for the given ContainerID
+func (store *MemoryStore) GetContainerMappings(containerID string) ([]*types.DNSMapping, error) {
 	txn := store.db.Txn(false)
 	defer txn.Abort()
 
-	iterator, err := txn.Get(tableName, "containerid")
+	return store.getContainerMappings(txn, containerID)
+}
+
+// getContainerMappings returns all DNSMappings of the ContainerID that are visible in the transaction
+func (store *MemoryStore) getContainerMappings(txn *memdb.Txn, containerID string) ([]*types.DNSMapping, error) {
+	iterator, err := txn.Get(tableName, "containerid", containerID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	missingItems := []*types.DNSMapping{}
+	mappings := []*types.DNSMapping{}
 	for item := iterator.Next(); item != nil; item = iterator.Next() {
 		dnsContainerList := item.(*types.DNSContainerList)
-		for _, containerID := range dnsContainerList.ContainerList {
-			mapping := &types.DNSMapping{
-				Name:        dnsContainerList.Name,
-				IP:          dnsContainerList.IP,
-				ContainerID: containerID,
-			}
-			if types.HasDNSMapping(mappings, mapping) {
-				break
-			}
-			missingItems = append(missingItems, &types.DNSMapping{
-				Name:        dnsContainerList.Name,
-				IP:          dnsContainerList.IP,
-				ContainerID: containerID,
-			})
-		}
+		mappings = append(mappings, &types.DNSMapping{
+			Name:        dnsContainerList.Name,
+			Type:        dnsContainerList.Type,
+			IP:          dnsContainerList.IP,
+			Settings:    dnsContainerList.GetContainerSettings(containerID),
+			ContainerID: containerID,
+		})
 	}
+	return mappings, nil
+}
 
-	for i := range mappings {
-		err := store.InsertMapping(mappings[i], provider.AddHostnameMapping)
-		if err != nil {
-			return err
-		}
+// GetRecords returns all A and AAAA records in the current state, together with the ContainerIDs backing them
+func (store *MemoryStore) GetRecords() ([]*types.DNSContainerList, error) {
+	txn := store.db.Txn(false)
+	defer txn.Abort()
+
+	iterator, err := txn.Get(tableName, "id")
+	if err != nil {
+		return nil, err
 	}
 
-	for i := range missingItems {
-		err := store.RemoveMapping(missingItems[i], provider.RemoveHostnameMapping)
-		if err != nil {
-			return err
+	records := []*types.DNSContainerList{}
+	for item := iterator.Next(); item != nil; item = iterator.Next() {
+		records = append(records, copyRecord(item.(*types.DNSContainerList)))
+	}
+	return records, nil
+}
+
+// PushOperation adds the operation to the queue, replacing any queued operation of the same DNSMapping
+func (store *MemoryStore) PushOperation(op *Operation) error {
+	store.queueMutex.Lock()
+	defer store.queueMutex.Unlock()
+	copied := *op
+	store.queue[string(getOperationKey(op.Mapping))] = &copied
+	return nil
+}
+
+// DeleteOperation removes the queued operation of the DNSMapping, if there is one
+func (store *MemoryStore) DeleteOperation(mapping *types.DNSMapping) error {
+	store.queueMutex.Lock()
+	defer store.queueMutex.Unlock()
+	delete(store.queue, string(getOperationKey(mapping)))
+	return nil
+}
+
+// DeleteContainerOperations removes all queued operations of the ContainerID
+func (store *MemoryStore) DeleteContainerOperations(containerID string) error {
+	store.queueMutex.Lock()
+	defer store.queueMutex.Unlock()
+	for key, op := range store.queue {
+		if op.Mapping.ContainerID == containerID {
+			delete(store.queue, key)
 		}
 	}
+	return nil
+}
 
+// ClearOperations removes all queued operations
+func (store *MemoryStore) ClearOperations() error {
+	store.queueMutex.Lock()
+	defer store.queueMutex.Unlock()
+	store.queue = map[string]*Operation{}
 	return nil
 }
+
+// GetOperations returns all queued operations, ordered by their ContainerID and DNSMapping
+func (store *MemoryStore) GetOperations() ([]*Operation, error) {
+	store.queueMutex.Lock()
+	defer store.queueMutex.Unlock()
+	keys := make([]string, 0, len(store.queue))
+	for key := range store.queue {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	ops := make([]*Operation, len(keys))
+	for i, key := range keys {
+		copied := *store.queue[key]
+		ops[i] = &copied
+	}
+	return ops, nil
+}
+
+// ipFieldIndex is a memdb indexer for the IP of a DNSContainerList
+// memdb has no builtin indexer for net.IP, so we index its string representation
+type ipFieldIndex struct{}
+
+// FromObject implements memdb.SingleIndexer
+func (*ipFieldIndex) FromObject(obj interface{}) (bool, []byte, error) {
+	record, ok := obj.(*types.DNSContainerList)
+	if !ok {
+		return false, nil, fmt.Errorf("cannot index the IP of %#v", obj)
+	}
+	if record.IP == nil {
+		return false, nil, nil
+	}
+	// Add the null character as a terminator, like the builtin string indexers
+	return true, []byte(record.IP.String() + "\x00"), nil
+}
+
+// FromArgs implements memdb.Indexer
+func (*ipFieldIndex) FromArgs(args ...interface{}) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("must provide only a single argument")
+	}
+	switch arg := args[0].(type) {
+	case string:
+		return []byte(arg + "\x00"), nil
+	case net.IP:
+		return []byte(arg.String() + "\x00"), nil
+	default:
+		return nil, fmt.Errorf("argument must be a string or net.IP: %#v", args[0])
+	}
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	metaBucketName   = "meta"
+	schemaVersionKey = "schema-version"
+	// schemaVersion is the version of the layout of the boltdb buckets that this version of dd-dns writes
+	// Databases without a version marker were written before versioning was introduced and have version 1
+	schemaVersion = 2
+)
+
+// migrations upgrade a boltdb database from the version of their index + 1 to the next version
+var migrations = []func(tx *bolt.Tx) error{
+	migrateRecordKeys,
+}
+
+// migrateSchema upgrades the layout of the buckets to the current schemaVersion
+// It must run before the buckets are created, to tell a new database from one without a version marker
+// All migrations run in the transaction, so a failed migration leaves the database untouched
+// Returns the version the database had before the migration
+func migrateSchema(tx *bolt.Tx) (int, error) {
+	meta, err := tx.CreateBucketIfNotExists([]byte(metaBucketName))
+	if err != nil {
+		return 0, err
+	}
+
+	version := 1
+	if rawVersion := meta.Get([]byte(schemaVersionKey)); rawVersion != nil {
+		if version, err = strconv.Atoi(string(rawVersion)); err != nil {
+			return 0, fmt.Errorf("invalid schema version %q: %w", rawVersion, err)
+		}
+	} else if tx.Bucket([]byte(bucketName)) == nil {
+		// A new database, there is nothing to migrate
+		version = schemaVersion
+	}
+	if version > schemaVersion {
+		return version, fmt.Errorf("the database has schema version %d, which is newer than the supported version %d", version, schemaVersion)
+	}
+
+	for v := version; v < schemaVersion; v++ {
+		if err := migrations[v-1](tx); err != nil {
+			return version, fmt.Errorf("failed to migrate the database to schema version %d: %w", v+1, err)
+		}
+	}
+	return version, meta.Put([]byte(schemaVersionKey), []byte(strconv.Itoa(schemaVersion)))
+}
+
+// migrateRecordKeys re-keys the records, retry queue and outbox with the keys that separate the hostname and the IP
+// (version 1 concatenated them, so distinct pairs could collide)
+func migrateRecordKeys(tx *bolt.Tx) error {
+	if err := rekeyBucket(tx.Bucket([]byte(bucketName)), func(v []byte) ([]byte, error) {
+		record, err := unmarshalRecord(v)
+		if err != nil {
+			return nil, err
+		}
+		return record.GetKey(), nil
+	}); err != nil {
+		return err
+	}
+
+	operationKey := func(v []byte) ([]byte, error) {
+		op := &Operation{}
+		if err := json.Unmarshal(v, op); err != nil {
+			return nil, err
+		}
+		return getOperationKey(op.Mapping), nil
+	}
+	if err := rekeyBucket(tx.Bucket([]byte(queueBucketName)), operationKey); err != nil {
+		return err
+	}
+	return rekeyBucket(tx.Bucket([]byte(outboxBucketName)), operationKey)
+}
+
+// rekeyBucket stores every value of the bucket under the key that getKey computes from it
+func rekeyBucket(bucket *bolt.Bucket, getKey func(v []byte) ([]byte, error)) error {
+	if bucket == nil {
+		return nil
+	}
+
+	// Modifying the bucket while iterating over it skips elements, so collect them first
+	keys := [][]byte{}
+	values := [][]byte{}
+	if err := bucket.ForEach(func(k, v []byte) error {
+		keys = append(keys, append([]byte{}, k...))
+		values = append(values, append([]byte{}, v...))
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := bucket.Delete(key); err != nil {
+			return err
+		}
+	}
+	for _, value := range values {
+		key, err := getKey(value)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
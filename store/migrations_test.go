@@ -0,0 +1,104 @@
+package store
+
+import (
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+	"github.com/wdullaer/dd-dns/types"
+	"go.uber.org/zap"
+)
+
+// writeLegacyDB creates a boltdb database with the layout of schema version 1, before it was versioned
+func writeLegacyDB(t *testing.T, dataDir string, records []*types.DNSContainerList, ops []*Operation) {
+	db, err := bolt.Open(filepath.Join(dataDir, "dd-dns.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("Failed to open boltdb: %s", err)
+	}
+	defer db.Close()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucket([]byte(bucketName))
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			payload, _ := json.Marshal(record)
+			if err := bucket.Put([]byte(record.Name+record.IP.String()), payload); err != nil {
+				return err
+			}
+		}
+		queue, err := tx.CreateBucket([]byte(queueBucketName))
+		if err != nil {
+			return err
+		}
+		for _, op := range ops {
+			payload, _ := json.Marshal(op)
+			if err := queue.Put([]byte(op.Mapping.ContainerID+"\x00"+op.Mapping.Name+op.Mapping.IP.String()), payload); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to write legacy database: %s", err)
+	}
+}
+
+func TestBoltDBStoreMigration(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	dataDir := t.TempDir()
+	record := &types.DNSContainerList{Name: "foo.example.com", Type: types.RecordTypeA, IP: net.ParseIP("192.168.0.1"), ContainerList: []string{"foo"}}
+	op := &Operation{Action: OperationRemove, Mapping: types.NewDNSMapping("bar.example.com", net.ParseIP("192.168.0.1"), "bar"), Attempts: 1}
+	writeLegacyDB(t, dataDir, []*types.DNSContainerList{record}, []*Operation{op})
+
+	store, err := NewBoltDBStore(logger, dataDir)
+	if !assert.NoError(t, err) {
+		return
+	}
+	records, err := store.GetRecords()
+	assert.NoError(t, err)
+	assert.Equal(t, []*types.DNSContainerList{record}, records)
+
+	// The migrated records and operations can be found by their new key
+	mapping := types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "foo")
+	provider := newTestProvider(t)
+	provider.Zone = map[string][]net.IP{"foo.example.com": {net.ParseIP("192.168.0.1")}}
+	assert.NoError(t, store.RemoveMapping(mapping, provider))
+	assert.Empty(t, provider.Zone)
+	assert.NoError(t, store.DeleteOperation(op.Mapping))
+	ops, err := store.GetOperations()
+	assert.NoError(t, err)
+	assert.Empty(t, ops)
+	store.CleanUp()
+
+	// The migration only runs once
+	store, err = NewBoltDBStore(logger, dataDir)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer store.CleanUp()
+	assert.NoError(t, store.db.View(func(tx *bolt.Tx) error {
+		assert.Equal(t, []byte("2"), tx.Bucket([]byte(metaBucketName)).Get([]byte(schemaVersionKey)))
+		return nil
+	}))
+}
+
+func TestBoltDBStoreNewerSchema(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	dataDir := t.TempDir()
+	store, err := NewBoltDBStore(logger, dataDir)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(metaBucketName)).Put([]byte(schemaVersionKey), []byte("3"))
+	}))
+	store.CleanUp()
+
+	_, err = NewBoltDBStore(logger, dataDir)
+	assert.Error(t, err)
+}
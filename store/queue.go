@@ -0,0 +1,116 @@
+package store
+
+import (
+	"errors"
+	"time"
+
+	"github.com/wdullaer/dd-dns/dns"
+	"github.com/wdullaer/dd-dns/types"
+)
+
+const (
+	// OperationAdd inserts the DNSMapping in the store and creates its record at the provider
+	OperationAdd = "add"
+	// OperationRemove removes the DNSMapping from the store and its record from the provider, if it was the last one
+	OperationRemove = "remove"
+	// retryMinDelay is the delay before the first retry of a failed operation
+	retryMinDelay = 30 * time.Second
+	// retryMaxDelay caps the exponential backoff between the retries of a failed operation
+	retryMaxDelay = time.Hour
+)
+
+// Operation is an add or remove of a DNSMapping that failed, and is queued to be retried
+type Operation struct {
+	Action      string            `json:"action"`
+	Mapping     *types.DNSMapping `json:"mapping"`
+	Attempts    int               `json:"attempts"`
+	NextAttempt time.Time         `json:"next-attempt"`
+}
+
+// RetryQueue persists the operations that failed, so they can be retried after a provider outage
+// There is at most 1 operation per DNSMapping (including its ContainerID), the latest one wins
+type RetryQueue interface {
+	// PushOperation adds the operation to the queue, replacing any queued operation of the same DNSMapping
+	PushOperation(op *Operation) error
+	// DeleteOperation removes the queued operation of the DNSMapping, if there is one
+	DeleteOperation(mapping *types.DNSMapping) error
+	// DeleteContainerOperations removes all queued operations of the ContainerID
+	DeleteContainerOperations(containerID string) error
+	// ClearOperations removes all queued operations
+	ClearOperations() error
+	// GetOperations returns all queued operations
+	GetOperations() ([]*Operation, error)
+}
+
+// ApplyOperation executes the operation on the store, which calls the dns.Provider if needed
+// If it fails, the operation is pushed onto the retry queue of the store, otherwise any queued operation
+// of the same DNSMapping is dropped, since it is outdated
+func ApplyOperation(store Store, provider dns.Provider, op *Operation) error {
+	err := executeOperation(store, provider, op)
+	if err == nil {
+		return store.DeleteOperation(op.Mapping)
+	}
+	return errors.Join(err, QueueOperation(store, op, time.Now()))
+}
+
+// QueueOperation pushes an operation that failed onto the retry queue of the store
+func QueueOperation(store Store, op *Operation, now time.Time) error {
+	op.Attempts++
+	op.NextAttempt = now.Add(getRetryDelay(op.Attempts))
+	return store.PushOperation(op)
+}
+
+// DrainRetryQueue retries the queued operations whose next attempt is due
+// Operations that succeed are removed from the queue, the others are retried later with an exponential backoff
+// It returns the operations that succeeded, and the errors of the ones that failed again
+func DrainRetryQueue(store Store, provider dns.Provider, now time.Time) ([]*Operation, error) {
+	ops, err := store.GetOperations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []*Operation{}
+	errs := []error{}
+	for _, op := range ops {
+		if op.NextAttempt.After(now) {
+			continue
+		}
+		if err := executeOperation(store, provider, op); err != nil {
+			errs = append(errs, err, QueueOperation(store, op, now))
+			continue
+		}
+		if err := store.DeleteOperation(op.Mapping); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		applied = append(applied, op)
+	}
+	return applied, errors.Join(errs...)
+}
+
+// executeOperation executes the operation on the store
+func executeOperation(store Store, provider dns.Provider, op *Operation) error {
+	switch op.Action {
+	case OperationAdd:
+		return store.InsertMapping(op.Mapping, provider.AddHostnameMapping)
+	case OperationRemove:
+		return store.RemoveMapping(op.Mapping, provider)
+	default:
+		// Drop operations we don't understand, rather than retrying them forever
+		return nil
+	}
+}
+
+// getRetryDelay returns the delay before the next attempt of an operation that failed the given number of times
+func getRetryDelay(attempts int) time.Duration {
+	delay := retryMinDelay
+	for i := 1; i < attempts && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, retryMaxDelay)
+}
+
+// getOperationKey returns a key that uniquely identifies the DNSMapping of an operation, including its ContainerID
+func getOperationKey(mapping *types.DNSMapping) []byte {
+	return append([]byte(mapping.ContainerID+"\x00"), mapping.GetKey()...)
+}
@@ -0,0 +1,165 @@
+package store
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wdullaer/dd-dns/types"
+	"go.uber.org/zap"
+)
+
+// outageProvider is a recordingProvider that fails every call while it is down
+type outageProvider struct {
+	*recordingProvider
+	down bool
+}
+
+func (provider *outageProvider) AddHostnameMapping(mapping *types.DNSMapping) error {
+	if provider.down {
+		return errors.New("provider is down")
+	}
+	return provider.recordingProvider.AddHostnameMapping(mapping)
+}
+
+func (provider *outageProvider) RemoveHostnameMapping(mapping *types.DNSMapping) error {
+	if provider.down {
+		return errors.New("provider is down")
+	}
+	return provider.recordingProvider.RemoveHostnameMapping(mapping)
+}
+
+func TestRetryQueue(t *testing.T) {
+	foo := types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "foo")
+	bar := types.NewDNSMapping("bar.example.com", net.ParseIP("192.168.0.1"), "bar")
+	now := time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			assert.NoError(t, store.PushOperation(&Operation{Action: OperationAdd, Mapping: foo, Attempts: 1, NextAttempt: now}))
+			assert.NoError(t, store.PushOperation(&Operation{Action: OperationAdd, Mapping: bar, Attempts: 1, NextAttempt: now}))
+			// The latest operation of a mapping replaces the queued one
+			assert.NoError(t, store.PushOperation(&Operation{Action: OperationRemove, Mapping: foo, Attempts: 2, NextAttempt: now}))
+
+			ops, err := store.GetOperations()
+			assert.NoError(t, err)
+			assert.Equal(t, []*Operation{
+				{Action: OperationAdd, Mapping: bar, Attempts: 1, NextAttempt: now},
+				{Action: OperationRemove, Mapping: foo, Attempts: 2, NextAttempt: now},
+			}, ops)
+
+			assert.NoError(t, store.DeleteContainerOperations("foo"))
+			ops, err = store.GetOperations()
+			assert.NoError(t, err)
+			assert.Equal(t, []*Operation{{Action: OperationAdd, Mapping: bar, Attempts: 1, NextAttempt: now}}, ops)
+
+			assert.NoError(t, store.DeleteOperation(bar))
+			ops, err = store.GetOperations()
+			assert.NoError(t, err)
+			assert.Empty(t, ops)
+
+			assert.NoError(t, store.PushOperation(&Operation{Action: OperationAdd, Mapping: foo, Attempts: 1, NextAttempt: now}))
+			assert.NoError(t, store.ClearOperations())
+			ops, err = store.GetOperations()
+			assert.NoError(t, err)
+			assert.Empty(t, ops)
+		})
+	}
+}
+
+func TestBoltDBStoreRetryQueuePersistence(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	dataDir := t.TempDir()
+	mapping := types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "foo")
+	op := &Operation{Action: OperationAdd, Mapping: mapping, Attempts: 1, NextAttempt: time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)}
+
+	store, err := NewBoltDBStore(logger, dataDir)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, store.PushOperation(op))
+	store.CleanUp()
+
+	store, err = NewBoltDBStore(logger, dataDir)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer store.CleanUp()
+	ops, err := store.GetOperations()
+	assert.NoError(t, err)
+	assert.Equal(t, []*Operation{op}, ops)
+}
+
+func TestDrainRetryQueue(t *testing.T) {
+	foo := types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "foo")
+	bar := types.NewDNSMapping("bar.example.com", net.ParseIP("192.168.0.1"), "bar")
+	now := time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			provider := &outageProvider{recordingProvider: &recordingProvider{DryrunProvider: newTestProvider(t)}, down: true}
+
+			// A failed operation is queued for a retry
+			assert.Error(t, ApplyOperation(store, provider, &Operation{Action: OperationAdd, Mapping: foo}))
+			ops, err := store.GetOperations()
+			assert.NoError(t, err)
+			assert.Len(t, ops, 1)
+
+			assert.NoError(t, store.ClearOperations())
+			for _, mapping := range []*types.DNSMapping{foo, bar} {
+				assert.NoError(t, QueueOperation(store, &Operation{Action: OperationAdd, Mapping: mapping}, now))
+			}
+
+			// Operations fail again while the provider is down, which increases their backoff
+			applied, err := DrainRetryQueue(store, provider, now.Add(retryMinDelay))
+			assert.Error(t, err)
+			assert.Empty(t, applied)
+			ops, err = store.GetOperations()
+			assert.NoError(t, err)
+			if assert.Len(t, ops, 2) {
+				assert.Equal(t, 2, ops[0].Attempts)
+				assert.Equal(t, now.Add(3*retryMinDelay), ops[0].NextAttempt)
+			}
+
+			// Only the operations that are due are retried once the provider is back up
+			provider.down = false
+			applied, err = DrainRetryQueue(store, provider, now.Add(2*retryMinDelay))
+			assert.NoError(t, err)
+			assert.Empty(t, applied)
+			applied, err = DrainRetryQueue(store, provider, now.Add(3*retryMinDelay))
+			assert.NoError(t, err)
+			assert.Len(t, applied, 2)
+			assert.Equal(t, map[string][]net.IP{
+				"foo.example.com": {net.ParseIP("192.168.0.1")},
+				"bar.example.com": {net.ParseIP("192.168.0.1")},
+			}, provider.Zone)
+
+			ops, err = store.GetOperations()
+			assert.NoError(t, err)
+			assert.Empty(t, ops)
+			mappings, err := store.GetContainerMappings("foo")
+			assert.NoError(t, err)
+			assert.Equal(t, []*types.DNSMapping{foo}, mappings)
+		})
+	}
+}
+
+func TestGetRetryDelay(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    int
+		expected time.Duration
+	}{
+		{name: "Should start at the minimum delay", input: 1, expected: retryMinDelay},
+		{name: "Should double on every attempt", input: 3, expected: 4 * retryMinDelay},
+		{name: "Should be capped at the maximum delay", input: 20, expected: retryMaxDelay},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, getRetryDelay(tc.input))
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/wdullaer/dd-dns/types"
+)
+
+// newRecord returns a record with the DNSMapping as its only container
+func newRecord(mapping *types.DNSMapping) *types.DNSContainerList {
+	return &types.DNSContainerList{
+		Name:          mapping.Name,
+		Type:          mapping.Type,
+		IP:            mapping.IP,
+		Settings:      mapping.Settings,
+		ContainerList: []string{mapping.ContainerID},
+	}
+}
+
+// addRecordContainer adds the ContainerID of the DNSMapping to the record and keeps track of the settings it wants
+// The settings of a shared record follow the first container, so they don't flip between containers
+func addRecordContainer(record *types.DNSContainerList, mapping *types.DNSMapping) {
+	if !slices.Contains(record.ContainerList, mapping.ContainerID) {
+		record.ContainerList = append(record.ContainerList, mapping.ContainerID)
+	}
+	if record.ContainerList[0] == mapping.ContainerID {
+		setRecordSettings(record, mapping.Settings)
+	}
+	setContainerSettings(record, mapping.ContainerID, mapping.Settings)
+}
+
+// removeRecordContainer removes the ContainerID from the record, keeping the order of the other containers
+// The settings of the record are left alone, getSettingsUpdate tells whether they have to follow a new first container
+func removeRecordContainer(record *types.DNSContainerList, containerID string) {
+	if index := slices.Index(record.ContainerList, containerID); index != -1 {
+		record.ContainerList = slices.Delete(record.ContainerList, index, index+1)
+	}
+	setContainerSettings(record, containerID, record.Settings)
+}
+
+// getSettingsUpdate returns the DNSMapping that applies the settings of the first container to the record
+// Returns nil if the record is empty or already has those settings
+func getSettingsUpdate(record *types.DNSContainerList) *types.DNSMapping {
+	if len(record.ContainerList) == 0 {
+		return nil
+	}
+	first := record.ContainerList[0]
+	settings := record.GetContainerSettings(first)
+	if settings == record.Settings {
+		return nil
+	}
+	return &types.DNSMapping{Name: record.Name, Type: record.Type, IP: record.IP, ContainerID: first, Settings: settings}
+}
+
+// setRecordSettings changes the settings of the record, its containers keep the settings they want
+func setRecordSettings(record *types.DNSContainerList, settings types.RecordSettings) {
+	wanted := make(map[string]types.RecordSettings, len(record.ContainerList))
+	for _, containerID := range record.ContainerList {
+		wanted[containerID] = record.GetContainerSettings(containerID)
+	}
+	record.Settings = settings
+	record.ContainerSettings = nil
+	for containerID, containerSettings := range wanted {
+		setContainerSettings(record, containerID, containerSettings)
+	}
+}
+
+// setContainerSettings records the settings the ContainerID wants, if they differ from the settings of the record
+// Otherwise the ContainerID is removed from the ContainerSettings, which are nil rather than empty
+func setContainerSettings(record *types.DNSContainerList, containerID string, settings types.RecordSettings) {
+	if settings == record.Settings {
+		delete(record.ContainerSettings, containerID)
+		if len(record.ContainerSettings) == 0 {
+			record.ContainerSettings = nil
+		}
+		return
+	}
+	if record.ContainerSettings == nil {
+		record.ContainerSettings = map[string]types.RecordSettings{}
+	}
+	record.ContainerSettings[containerID] = settings
+}
+
+// copyRecord returns a deep copy of a DNSContainerList
+// Objects that are stored in memdb must never be modified in place, since that would also
+// modify the state of any other transaction (including aborted ones)
+func copyRecord(record *types.DNSContainerList) *types.DNSContainerList {
+	copied := *record
+	copied.ContainerList = append([]string{}, record.ContainerList...)
+	copied.ContainerSettings = maps.Clone(record.ContainerSettings)
+	return &copied
+}
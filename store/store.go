@@ -6,20 +6,43 @@ import (
 )
 
 // Store provides methods to interact with the desired state that will be provisioned on the dns.Provider
+// It also keeps the operations that failed, so they can be retried
 type Store interface {
+	RetryQueue
 	// CleanUp ensures any pending operations on the store are executed before closing down
 	CleanUp()
-	// InsertMapping registers that the ContainerID of the DNSMapping supports an A record
-	// In case the A record is not present in the current state, the callback will be executed
-	// which should create it at the DNSProvider
+	// RecoverOutbox executes the dns.Provider calls of changes that were interrupted by a crash
+	// It should be called on startup, before any other change is made
+	RecoverOutbox(provider dns.Provider) error
+	// InsertMapping registers that the ContainerID of the DNSMapping supports an A or AAAA record
+	// In case the record is not present in the current state, or its settings changed, the callback will be executed
+	// which should create or update it at the DNSProvider
 	// TODO: maybe pass a dns.Provider, rather than a generic callback
 	InsertMapping(mapping *types.DNSMapping, cb func(*types.DNSMapping) error) error
-	// RemoveMapping removes the ContainerID from the list backing the A record
-	// In case this was the last ContainerID in the list, the callback will be executed
-	// to remove the A record from the DNSProvider
-	RemoveMapping(mapping *types.DNSMapping, cb func(*types.DNSMapping) error) error
+	// RemoveMapping removes the ContainerID from the list backing the A or AAAA record
+	// In case this was the last ContainerID in the list, the record is removed from the dns.Provider
+	// Otherwise, if the record has to take the settings of its new first ContainerID, it is updated at the dns.Provider
+	RemoveMapping(mapping *types.DNSMapping, provider dns.Provider) error
 	// ReplaceMappings will replace the current list of DNSMappings with the supplied list
 	// It will interact with the dns.Provider to ensure the remote state is in sync
-	// It will perform a diff with the current state to minimize the amount of API calls to the dns.Provider
+	// It will perform a diff with the current state (see DiffMappings) to minimize the amount of API calls to the dns.Provider
 	ReplaceMappings(mappings []*types.DNSMapping, provider dns.Provider) error
+	// UpdateContainerMappings replaces the DNSMappings of a single container with the supplied list
+	// It will interact with the dns.Provider to add new records and remove records that are no longer referenced
+	UpdateContainerMappings(containerID string, mappings []*types.DNSMapping, provider dns.Provider) error
+	// GetContainerMappings returns all DNSMappings that are currently registered for the given ContainerID
+	GetContainerMappings(containerID string) ([]*types.DNSMapping, error)
+	// GetRecords returns all A and AAAA records in the current state, together with the ContainerIDs backing them
+	GetRecords() ([]*types.DNSContainerList, error)
+}
+
+// startBatch starts a batch if the dns.Provider supports them (see dns.BatchProvider)
+// It returns a function that ends the batch, which is a no-op otherwise
+func startBatch(provider dns.Provider) func() {
+	batchProvider, ok := provider.(dns.BatchProvider)
+	if !ok {
+		return func() {}
+	}
+	batchProvider.StartBatch()
+	return batchProvider.EndBatch
 }
@@ -0,0 +1,309 @@
+package store
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wdullaer/dd-dns/dns"
+	"github.com/wdullaer/dd-dns/types"
+	"go.uber.org/zap"
+)
+
+// newTestStores returns a fresh instance of every Store implementation, keyed by name
+func newTestStores(t *testing.T) map[string]Store {
+	logger := zap.NewNop().Sugar()
+	memoryStore, err := NewMemoryStore(logger)
+	if err != nil {
+		t.Fatalf("Failed to create memory store: %s", err)
+	}
+	boltdbStore, err := NewBoltDBStore(logger, t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create boltdb store: %s", err)
+	}
+	t.Cleanup(boltdbStore.CleanUp)
+	return map[string]Store{"memory": memoryStore, "boltdb": boltdbStore}
+}
+
+// newTestProvider returns an empty DryrunProvider
+func newTestProvider(t *testing.T) *dns.DryrunProvider {
+	provider, err := dns.NewDryrunProvider(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatalf("Failed to create dryrun provider: %s", err)
+	}
+	return provider
+}
+
+func TestStoreRecordTypes(t *testing.T) {
+	mappingA := types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "foo")
+	mappingAAAA := types.NewDNSMapping("foo.example.com", net.ParseIP("2001:db8::1"), "foo")
+
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			provider := newTestProvider(t)
+			assert.NoError(t, store.InsertMapping(mappingA, provider.AddHostnameMapping))
+			assert.NoError(t, store.InsertMapping(mappingAAAA, provider.AddHostnameMapping))
+
+			records, err := store.GetRecords()
+			assert.NoError(t, err)
+			assert.Equal(t, []*types.DNSContainerList{
+				{Name: "foo.example.com", Type: types.RecordTypeA, IP: net.ParseIP("192.168.0.1"), ContainerList: []string{"foo"}},
+				{Name: "foo.example.com", Type: types.RecordTypeAAAA, IP: net.ParseIP("2001:db8::1"), ContainerList: []string{"foo"}},
+			}, records)
+			assert.Equal(t, map[string][]net.IP{"foo.example.com": {net.ParseIP("192.168.0.1"), net.ParseIP("2001:db8::1")}}, provider.Zone)
+
+			// Removing the AAAA record must leave the A record of the same hostname alone
+			assert.NoError(t, store.RemoveMapping(mappingAAAA, provider))
+			mappings, err := store.GetContainerMappings("foo")
+			assert.NoError(t, err)
+			assert.Equal(t, []*types.DNSMapping{mappingA}, mappings)
+			assert.Equal(t, map[string][]net.IP{"foo.example.com": {net.ParseIP("192.168.0.1")}}, provider.Zone)
+
+			assert.NoError(t, store.RemoveMapping(mappingA, provider))
+			records, err = store.GetRecords()
+			assert.NoError(t, err)
+			assert.Empty(t, records)
+			assert.Empty(t, provider.Zone)
+		})
+	}
+}
+
+func TestStoreSharedRecord(t *testing.T) {
+	mappingFoo := types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "foo")
+	mappingBar := types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "bar")
+
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			provider := newTestProvider(t)
+			assert.NoError(t, store.InsertMapping(mappingFoo, provider.AddHostnameMapping))
+			assert.NoError(t, store.InsertMapping(mappingBar, provider.AddHostnameMapping))
+
+			// Every container that shares the record must be able to find it
+			for _, mapping := range []*types.DNSMapping{mappingFoo, mappingBar} {
+				mappings, err := store.GetContainerMappings(mapping.ContainerID)
+				assert.NoError(t, err)
+				assert.Equal(t, []*types.DNSMapping{mapping}, mappings)
+			}
+
+			assert.NoError(t, store.RemoveMapping(mappingFoo, provider))
+			assert.Equal(t, map[string][]net.IP{"foo.example.com": {net.ParseIP("192.168.0.1")}}, provider.Zone)
+
+			assert.NoError(t, store.RemoveMapping(mappingBar, provider))
+			assert.Empty(t, provider.Zone)
+		})
+	}
+}
+
+// recordingProvider is a DryrunProvider that keeps track of the order in which records are added and removed
+type recordingProvider struct {
+	*dns.DryrunProvider
+	calls []string
+}
+
+func (provider *recordingProvider) AddHostnameMapping(mapping *types.DNSMapping) error {
+	provider.calls = append(provider.calls, "add "+mapping.Name+" "+mapping.IP.String())
+	return provider.DryrunProvider.AddHostnameMapping(mapping)
+}
+
+func (provider *recordingProvider) RemoveHostnameMapping(mapping *types.DNSMapping) error {
+	provider.calls = append(provider.calls, "remove "+mapping.Name+" "+mapping.IP.String())
+	return provider.DryrunProvider.RemoveHostnameMapping(mapping)
+}
+
+func TestStoreUpdateContainerMappings(t *testing.T) {
+	cases := []struct {
+		name          string
+		initial       []*types.DNSMapping
+		input         []*types.DNSMapping
+		expectedCalls []string
+		expectedZone  map[string][]net.IP
+	}{
+		{
+			name:          "Should add the new records before removing the old ones",
+			initial:       []*types.DNSMapping{types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "foo")},
+			input:         []*types.DNSMapping{types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.2"), "foo")},
+			expectedCalls: []string{"add foo.example.com 192.168.0.2", "remove foo.example.com 192.168.0.1"},
+			expectedZone:  map[string][]net.IP{"foo.example.com": {net.ParseIP("192.168.0.2")}},
+		},
+		{
+			name: "Should not remove a record that is shared with another container",
+			initial: []*types.DNSMapping{
+				types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "foo"),
+				types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "bar"),
+			},
+			input:         []*types.DNSMapping{types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.2"), "foo")},
+			expectedCalls: []string{"add foo.example.com 192.168.0.2"},
+			expectedZone:  map[string][]net.IP{"foo.example.com": {net.ParseIP("192.168.0.1"), net.ParseIP("192.168.0.2")}},
+		},
+		{
+			name: "Should remove all records of a container that was disconnected",
+			initial: []*types.DNSMapping{
+				types.NewDNSMapping("a.example.com", net.ParseIP("192.168.0.1"), "foo"),
+				types.NewDNSMapping("b.example.com", net.ParseIP("192.168.0.1"), "foo"),
+			},
+			input:         nil,
+			expectedCalls: []string{"remove a.example.com 192.168.0.1", "remove b.example.com 192.168.0.1"},
+			expectedZone:  map[string][]net.IP{},
+		},
+		{
+			name:          "Should not call the provider if nothing changed",
+			initial:       []*types.DNSMapping{types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "foo")},
+			input:         []*types.DNSMapping{types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "foo")},
+			expectedCalls: []string{},
+			expectedZone:  map[string][]net.IP{"foo.example.com": {net.ParseIP("192.168.0.1")}},
+		},
+	}
+
+	for _, tc := range cases {
+		for name, store := range newTestStores(t) {
+			t.Run(tc.name+"/"+name, func(t *testing.T) {
+				provider := &recordingProvider{DryrunProvider: newTestProvider(t)}
+				for _, mapping := range tc.initial {
+					if !assert.NoError(t, store.InsertMapping(mapping, provider.AddHostnameMapping)) {
+						return
+					}
+				}
+				provider.calls = []string{}
+
+				assert.NoError(t, store.UpdateContainerMappings("foo", tc.input, provider))
+				assert.Equal(t, tc.expectedCalls, provider.calls)
+				assert.Equal(t, tc.expectedZone, provider.Zone)
+
+				mappings, err := store.GetContainerMappings("foo")
+				assert.NoError(t, err)
+				assert.ElementsMatch(t, tc.input, mappings)
+			})
+		}
+	}
+}
+
+func TestStoreRecordSettings(t *testing.T) {
+	newMapping := func(containerID string, ttl int) *types.DNSMapping {
+		return &types.DNSMapping{
+			Name:        "foo.example.com",
+			Type:        types.RecordTypeA,
+			IP:          net.ParseIP("192.168.0.1"),
+			ContainerID: containerID,
+			Settings:    types.RecordSettings{TTL: ttl},
+		}
+	}
+
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			provider := &recordingProvider{DryrunProvider: newTestProvider(t)}
+			assert.NoError(t, store.InsertMapping(newMapping("foo", 60), provider.AddHostnameMapping))
+
+			// Inserting the same mapping again is a no-op
+			assert.NoError(t, store.InsertMapping(newMapping("foo", 60), provider.AddHostnameMapping))
+			assert.Equal(t, []string{"add foo.example.com 192.168.0.1"}, provider.calls)
+
+			// Changed settings are pushed to the provider
+			assert.NoError(t, store.InsertMapping(newMapping("foo", 120), provider.AddHostnameMapping))
+			assert.Equal(t, []string{"add foo.example.com 192.168.0.1", "add foo.example.com 192.168.0.1"}, provider.calls)
+
+			// Another container sharing the record does not override the settings
+			assert.NoError(t, store.InsertMapping(newMapping("bar", 300), provider.AddHostnameMapping))
+			assert.Len(t, provider.calls, 2)
+
+			records, err := store.GetRecords()
+			assert.NoError(t, err)
+			assert.Equal(t, []*types.DNSContainerList{{
+				Name:          "foo.example.com",
+				Type:          types.RecordTypeA,
+				IP:            net.ParseIP("192.168.0.1"),
+				Settings:      types.RecordSettings{TTL: 120},
+				ContainerList: []string{"foo", "bar"},
+				// The settings of bar are kept, so they can be applied once foo is removed
+				ContainerSettings: map[string]types.RecordSettings{"bar": {TTL: 300}},
+			}}, records)
+
+			// UpdateContainerMappings picks up changed settings of existing mappings
+			assert.NoError(t, store.UpdateContainerMappings("foo", []*types.DNSMapping{newMapping("foo", 600)}, provider))
+			assert.Len(t, provider.calls, 3)
+			mappings, err := store.GetContainerMappings("foo")
+			assert.NoError(t, err)
+			assert.Equal(t, []*types.DNSMapping{newMapping("foo", 600)}, mappings)
+			mappings, err = store.GetContainerMappings("bar")
+			assert.NoError(t, err)
+			assert.Equal(t, []*types.DNSMapping{newMapping("bar", 300)}, mappings)
+		})
+	}
+}
+
+func TestStoreRemoveFirstContainerSettings(t *testing.T) {
+	newMapping := func(containerID string, settings types.RecordSettings) *types.DNSMapping {
+		return &types.DNSMapping{
+			Name:        "foo.example.com",
+			Type:        types.RecordTypeA,
+			IP:          net.ParseIP("192.168.0.1"),
+			ContainerID: containerID,
+			Settings:    settings,
+		}
+	}
+	fooSettings := types.RecordSettings{TTL: 60, Comment: types.ManagedComment + ", container foo"}
+	barSettings := types.RecordSettings{TTL: 300, Comment: types.ManagedComment + ", container bar"}
+
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			provider := &outageProvider{recordingProvider: &recordingProvider{DryrunProvider: newTestProvider(t)}}
+			assert.NoError(t, store.InsertMapping(newMapping("foo", fooSettings), provider.AddHostnameMapping))
+			assert.NoError(t, store.InsertMapping(newMapping("bar", barSettings), provider.AddHostnameMapping))
+			assert.NoError(t, store.InsertMapping(newMapping("baz", fooSettings), provider.AddHostnameMapping))
+
+			// A failed update is returned, so the removal can be retried
+			provider.down = true
+			assert.Error(t, store.RemoveMapping(newMapping("foo", fooSettings), provider))
+			provider.down = false
+			assert.NoError(t, store.RemoveMapping(newMapping("foo", fooSettings), provider))
+
+			// The record takes the settings of bar, which is now the first container
+			assert.Equal(t, []string{"add foo.example.com 192.168.0.1", "add foo.example.com 192.168.0.1"}, provider.calls)
+			records, err := store.GetRecords()
+			assert.NoError(t, err)
+			assert.Equal(t, []*types.DNSContainerList{{
+				Name:              "foo.example.com",
+				Type:              types.RecordTypeA,
+				IP:                net.ParseIP("192.168.0.1"),
+				Settings:          barSettings,
+				ContainerList:     []string{"bar", "baz"},
+				ContainerSettings: map[string]types.RecordSettings{"baz": fooSettings},
+			}}, records)
+
+			// Removing a container that is not the first one leaves the settings alone
+			assert.NoError(t, store.RemoveMapping(newMapping("baz", fooSettings), provider))
+			assert.Len(t, provider.calls, 2)
+			records, err = store.GetRecords()
+			assert.NoError(t, err)
+			assert.Equal(t, []*types.DNSContainerList{{
+				Name:          "foo.example.com",
+				Type:          types.RecordTypeA,
+				IP:            net.ParseIP("192.168.0.1"),
+				Settings:      barSettings,
+				ContainerList: []string{"bar"},
+			}}, records)
+		})
+	}
+}
+
+// batchProvider is a DryrunProvider that counts the batches that were started and ended
+type batchProvider struct {
+	*dns.DryrunProvider
+	started int
+	ended   int
+}
+
+func (provider *batchProvider) StartBatch() { provider.started++ }
+
+func (provider *batchProvider) EndBatch() { provider.ended++ }
+
+func TestStoreReplaceMappingsBatch(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			provider := &batchProvider{DryrunProvider: newTestProvider(t)}
+			assert.NoError(t, store.ReplaceMappings([]*types.DNSMapping{types.NewDNSMapping("foo.example.com", net.ParseIP("192.168.0.1"), "foo")}, provider))
+			assert.Equal(t, 1, provider.started)
+			assert.Equal(t, 1, provider.ended)
+			assert.Equal(t, map[string][]net.IP{"foo.example.com": {net.ParseIP("192.168.0.1")}}, provider.Zone)
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/wdullaer/dd-dns/stringslice"
+	"go.uber.org/zap"
+)
+
+const (
+	// enableLabel is the docker label with which a container opts in to the hostname-template
+	enableLabel = "dd-dns.enable"
+	// composeProjectLabel is the docker label in which docker compose stores the project of a container
+	composeProjectLabel = "com.docker.compose.project"
+	// composeServiceLabel is the docker label in which docker compose stores the service of a container
+	composeServiceLabel = "com.docker.compose.service"
+	// stackNamespaceLabel is the docker label in which docker stack stores the stack of a swarm service
+	stackNamespaceLabel = "com.docker.stack.namespace"
+)
+
+// hostnameTemplateFuncs are the functions that can be used in the hostname-template, besides the builtin ones
+var hostnameTemplateFuncs = template.FuncMap{
+	"lower":      strings.ToLower,
+	"replace":    strings.ReplaceAll,
+	"trimPrefix": strings.TrimPrefix,
+	"trimSuffix": strings.TrimSuffix,
+}
+
+// hostnameTemplateData is the container or service metadata the hostname-template is evaluated against
+type hostnameTemplateData struct {
+	// ID is the ID of the container or service
+	ID string
+	// Name is the name of the container (without the leading slash) or service
+	Name string
+	// Image is the image of the container or service
+	Image string
+	// Project is the docker compose project of a container, or the stack of a swarm service
+	Project string
+	// Service is the docker compose service of a container, or the name of a swarm service without its stack
+	Service string
+	// Labels are all labels of the container or service
+	Labels map[string]string
+}
+
+// newContainerTemplateData returns the metadata of a container for the hostname-template
+func newContainerTemplateData(container *container.Summary) *hostnameTemplateData {
+	return &hostnameTemplateData{
+		ID:      container.ID,
+		Name:    getContainerName(container),
+		Image:   container.Image,
+		Project: container.Labels[composeProjectLabel],
+		Service: container.Labels[composeServiceLabel],
+		Labels:  container.Labels,
+	}
+}
+
+// newServiceTemplateData returns the metadata of a swarm service for the hostname-template
+func newServiceTemplateData(service *swarm.Service) *hostnameTemplateData {
+	data := &hostnameTemplateData{
+		ID:      service.ID,
+		Name:    service.Spec.Name,
+		Project: service.Spec.Labels[stackNamespaceLabel],
+		Labels:  service.Spec.Labels,
+	}
+	if service.Spec.TaskTemplate.ContainerSpec != nil {
+		data.Image = service.Spec.TaskTemplate.ContainerSpec.Image
+	}
+	data.Service = strings.TrimPrefix(data.Name, data.Project+"_")
+	return data
+}
+
+// parseHostnameTemplate parses the hostname-template
+func parseHostnameTemplate(text string) (*template.Template, error) {
+	return template.New("hostname-template").Funcs(hostnameTemplateFuncs).Option("missingkey=zero").Parse(text)
+}
+
+// getTemplateHostnames renders the hostname-template for a container or service that opted in with the dd-dns.enable label
+// The template can render multiple hostnames, separated by commas or whitespace. Invalid hostnames are logged and skipped
+// Returns an error if the label is invalid, or if the template can't be rendered
+func getTemplateHostnames(data *hostnameTemplateData, config *config, logger *zap.SugaredLogger) ([]string, error) {
+	if config.HostnameTemplate == "" {
+		return nil, nil
+	}
+	label, ok := data.Labels[enableLabel]
+	if !ok {
+		return nil, nil
+	}
+	enabled, err := strconv.ParseBool(strings.TrimSpace(label))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s label `%s`, it must be a boolean", enableLabel, label)
+	}
+	if !enabled {
+		return nil, nil
+	}
+
+	tmpl, err := parseHostnameTemplate(config.HostnameTemplate)
+	if err != nil {
+		return nil, err
+	}
+	var output strings.Builder
+	if err := tmpl.Execute(&output, data); err != nil {
+		return nil, fmt.Errorf("failed to render the hostname-template: %w", err)
+	}
+
+	return appendHostnames(nil, strings.FieldsFunc(output.String(), isHostnameSeparator), "hostname-template", logger), nil
+}
+
+// appendTemplateHostnames appends the hostnames rendered by the hostname-template to hostnames, without duplicates
+// If the template can't be rendered, this is logged and the other hostnames are returned unchanged
+func appendTemplateHostnames(hostnames []string, data *hostnameTemplateData, config *config, logger *zap.SugaredLogger) []string {
+	templateHostnames, err := getTemplateHostnames(data, config, logger)
+	if err != nil {
+		logger.Warnw("Skipping the hostname-template", "err", err)
+		return hostnames
+	}
+	for _, hostname := range templateHostnames {
+		if !stringslice.Contains(hostnames, hostname) {
+			hostnames = append(hostnames, hostname)
+		}
+	}
+	return hostnames
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestNewTemplateData(t *testing.T) {
+	t.Run("Should read the compose labels of a container", func(t *testing.T) {
+		labels := map[string]string{composeProjectLabel: "shop", composeServiceLabel: "web"}
+		output := newContainerTemplateData(&container.Summary{ID: "foo", Names: []string{"/shop-web-1"}, Image: "nginx", Labels: labels})
+		assert.Equal(t, &hostnameTemplateData{ID: "foo", Name: "shop-web-1", Image: "nginx", Project: "shop", Service: "web", Labels: labels}, output)
+	})
+
+	t.Run("Should strip the stack from the name of a service", func(t *testing.T) {
+		labels := map[string]string{stackNamespaceLabel: "shop"}
+		service := &swarm.Service{ID: "foo"}
+		service.Spec.Name = "shop_web"
+		service.Spec.Labels = labels
+		service.Spec.TaskTemplate.ContainerSpec = &swarm.ContainerSpec{Image: "nginx"}
+		output := newServiceTemplateData(service)
+		assert.Equal(t, &hostnameTemplateData{ID: "foo", Name: "shop_web", Image: "nginx", Project: "shop", Service: "web", Labels: labels}, output)
+	})
+}
+
+func TestGetTemplateHostnames(t *testing.T) {
+	cases := []struct {
+		name     string
+		template string
+		input    map[string]string
+		expected []string
+		error    bool
+	}{
+		{
+			name:     "Should render the template for a container that opted in",
+			template: "{{.Service}}.{{.Project}}.apps.example.com",
+			input:    map[string]string{enableLabel: "true"},
+			expected: []string{"web.shop.apps.example.com"},
+			error:    false,
+		},
+		{
+			name:     "Should not render the template without the enable label",
+			template: "{{.Service}}.{{.Project}}.apps.example.com",
+			input:    map[string]string{},
+			expected: nil,
+			error:    false,
+		},
+		{
+			name:     "Should not render the template for a container that opted out",
+			template: "{{.Service}}.{{.Project}}.apps.example.com",
+			input:    map[string]string{enableLabel: "false"},
+			expected: nil,
+			error:    false,
+		},
+		{
+			name:     "Should not render anything without a template",
+			template: "",
+			input:    map[string]string{enableLabel: "true"},
+			expected: nil,
+			error:    false,
+		},
+		{
+			name:     "Should render multiple hostnames and labels",
+			template: `{{.Service}}.example.com, {{index .Labels "alias" | lower}}.example.com`,
+			input:    map[string]string{enableLabel: "true", "alias": "Store"},
+			expected: []string{"web.example.com", "store.example.com"},
+			error:    false,
+		},
+		{
+			name:     "Should reject an invalid enable label",
+			template: "{{.Service}}.example.com",
+			input:    map[string]string{enableLabel: "yes please"},
+			expected: nil,
+			error:    true,
+		},
+		{
+			name:     "Should skip an invalid rendered hostname and keep the valid ones",
+			template: "{{.Name}}.example.com {{.Service}}.example.com",
+			input:    map[string]string{enableLabel: "true"},
+			expected: []string{"web.example.com"},
+			error:    false,
+		},
+		{
+			name:     "Should skip a rendered hostname with an empty label",
+			template: `{{index .Labels "missing"}}.example.com`,
+			input:    map[string]string{enableLabel: "true"},
+			expected: nil,
+			error:    false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			data := &hostnameTemplateData{Name: "shop_web_1", Project: "shop", Service: "web", Labels: tc.input}
+			output, err := getTemplateHostnames(data, &config{HostnameTemplate: tc.template}, zap.NewNop().Sugar())
+			if tc.error {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expected, output)
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package types
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// hostnameLabel matches a single label of a hostname in its ASCII form (RFC 1035 and RFC 1123)
+var hostnameLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// hostnameProfile maps internationalized hostnames to their lowercase punycode form
+// It rejects characters that are not allowed in hostnames (eg: underscores) and names that are too long
+var hostnameProfile = idna.New(
+	idna.MapForLookup(),
+	idna.BidiRule(),
+	idna.StrictDomainName(true),
+	idna.VerifyDNSLength(true),
+)
+
+// NormalizeHostname validates a hostname and returns its canonical form, which can be published and used as a key
+// The hostname is lowercased, its trailing dot is removed and internationalized names are converted to punycode
+// (eg: `Bücher.Example.com.` becomes `xn--bcher-kva.example.com`). A leading `*.` wildcard label is allowed
+func NormalizeHostname(hostname string) (string, error) {
+	name := strings.TrimSuffix(strings.TrimSpace(hostname), ".")
+	wildcard := strings.HasPrefix(name, "*.")
+	if wildcard {
+		name = strings.TrimPrefix(name, "*.")
+	}
+
+	name, err := hostnameProfile.ToASCII(name)
+	if err != nil {
+		return "", fmt.Errorf("invalid hostname `%s`: %w", hostname, err)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if !hostnameLabel.MatchString(label) {
+			return "", fmt.Errorf("invalid hostname `%s`: label `%s` must be 1 to 63 letters, digits or hyphens, that don't start or end with a hyphen", hostname, label)
+		}
+	}
+
+	if wildcard {
+		name = "*." + name
+	}
+	if len(name) > 253 {
+		return "", fmt.Errorf("invalid hostname `%s`: it is longer than 253 characters", hostname)
+	}
+	return name, nil
+}
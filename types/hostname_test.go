@@ -0,0 +1,96 @@
+package types
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeHostname(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+		error    bool
+	}{
+		{
+			name:     "Should keep a valid hostname",
+			input:    "www.example.com",
+			expected: "www.example.com",
+		},
+		{
+			name:     "Should lowercase the hostname",
+			input:    "WWW.Example.COM",
+			expected: "www.example.com",
+		},
+		{
+			name:     "Should strip the trailing dot",
+			input:    "www.example.com.",
+			expected: "www.example.com",
+		},
+		{
+			name:     "Should convert an internationalized hostname to punycode",
+			input:    "Bücher.example.com",
+			expected: "xn--bcher-kva.example.com",
+		},
+		{
+			name:     "Should keep a punycode hostname",
+			input:    "xn--bcher-kva.example.com",
+			expected: "xn--bcher-kva.example.com",
+		},
+		{
+			name:     "Should allow a leading wildcard",
+			input:    "*.Example.com.",
+			expected: "*.example.com",
+		},
+		{
+			name:  "Should reject a wildcard that is not the first label",
+			input: "www.*.example.com",
+			error: true,
+		},
+		{
+			name:  "Should reject an underscore",
+			input: "my_app.example.com",
+			error: true,
+		},
+		{
+			name:  "Should reject a label that starts with a hyphen",
+			input: "-www.example.com",
+			error: true,
+		},
+		{
+			name:  "Should reject an empty label",
+			input: "www..example.com",
+			error: true,
+		},
+		{
+			name:  "Should reject an empty hostname",
+			input: " ",
+			error: true,
+		},
+		{
+			name:  "Should reject a label that is longer than 63 characters",
+			input: strings.Repeat("a", 64) + ".example.com",
+			error: true,
+		},
+		{
+			name:  "Should reject a hostname that is longer than 253 characters",
+			input: strings.Repeat(strings.Repeat("a", 63)+".", 4) + "com",
+			error: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := NormalizeHostname(tc.input)
+			if tc.error && err == nil {
+				t.Errorf("Expected `NormalizeHostname` with input `%s` to return an error, got `%s`", tc.input, output)
+			}
+			if !tc.error && err != nil {
+				t.Errorf("Expected `NormalizeHostname` with input `%s` to not return an error, got `%s`", tc.input, err)
+			}
+			if output != tc.expected {
+				t.Errorf("Expected `NormalizeHostname` with input `%s` to return `%s`, got `%s`", tc.input, tc.expected, output)
+			}
+		})
+	}
+}
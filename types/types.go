@@ -4,31 +4,98 @@ import (
 	"net"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
+const (
+	// RecordTypeA is the type of a DNS record that maps a hostname to an IPv4 address
+	RecordTypeA = "A"
+	// RecordTypeAAAA is the type of a DNS record that maps a hostname to an IPv6 address
+	RecordTypeAAAA = "AAAA"
+	// ManagedComment is the start of the comment of every record dd-dns creates at providers that support comments
+	ManagedComment = "managed by dd-dns"
+)
+
+// RecordSettings holds the optional settings of a DNS record
+// The zero value of each setting means that the default of the DNS provider is used
+// Providers ignore the settings they do not support
+type RecordSettings struct {
+	// TTL is the time to live of the record in seconds
+	TTL int `json:",omitempty"`
+	// Proxied routes the traffic of the record through the proxy of the provider (Cloudflare only)
+	Proxied bool `json:",omitempty"`
+	// Comment is a free form description of the record (Cloudflare only)
+	Comment string `json:",omitempty"`
+}
+
 // DNSContainerList is a type that keeps track of which containerIDs are associated with a (hostname, IP) pair
+// The settings are those of the record at the provider, which follow the first container in the list
+// ContainerSettings holds the settings of the containers that want different settings than the record has
 type DNSContainerList struct {
-	Name          string
-	IP            net.IP
-	ContainerList []string
+	Name              string
+	Type              string
+	IP                net.IP
+	Settings          RecordSettings
+	ContainerList     []string
+	ContainerSettings map[string]RecordSettings `json:",omitempty"`
+}
+
+// GetContainerSettings returns the settings the ContainerID wants for the record
+func (list *DNSContainerList) GetContainerSettings(containerID string) RecordSettings {
+	if settings, ok := list.ContainerSettings[containerID]; ok {
+		return settings
+	}
+	return list.Settings
 }
 
 // DNSMapping is a type that represents a Container and its associated (hostname, IP) pair
 type DNSMapping struct {
 	Name        string
+	Type        string
 	ContainerID string
 	IP          net.IP
+	Settings    RecordSettings
+}
+
+// NewDNSMapping creates a DNSMapping with the record type that matches the IP address
+func NewDNSMapping(name string, ip net.IP, containerID string) *DNSMapping {
+	return &DNSMapping{
+		Name:        name,
+		Type:        GetRecordType(ip),
+		IP:          ip,
+		ContainerID: containerID,
+	}
+}
+
+// GetRecordType returns the type of the DNS record that can hold the IP address (A or AAAA)
+func GetRecordType(ip net.IP) string {
+	if ip.To4() != nil {
+		return RecordTypeA
+	}
+	return RecordTypeAAAA
 }
 
 // GetKey produces a byte array that can be used as a unique key for this record for us in eg Boltdb
+// The hostname and IP are separated by a null byte, which can't occur in either, so distinct pairs never collide
 func (mapping *DNSMapping) GetKey() []byte {
-	return []byte(mapping.Name + mapping.IP.String())
+	return getKey(mapping.Name, mapping.IP)
+}
+
+// GetKey produces the same key as the GetKey of the DNSMappings of this record
+func (list *DNSContainerList) GetKey() []byte {
+	return getKey(list.Name, list.IP)
+}
+
+// getKey produces the unique key of a (hostname, IP) pair
+func getKey(name string, ip net.IP) []byte {
+	return []byte(name + "\x00" + ip.String())
 }
 
 // HasDNSMapping checks if a slice of DNSMapping pointers contains a particular mapping by value
+// The settings are not part of the identity of a mapping, so they are ignored
 func HasDNSMapping(col []*DNSMapping, item *DNSMapping) bool {
 	for i := range col {
-		if cmp.Equal(*col[i], *item) {
+		if cmp.Equal(*col[i], *item, cmpopts.IgnoreFields(DNSMapping{}, "Settings")) {
 			return true
 		}
 	}
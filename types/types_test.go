@@ -24,6 +24,12 @@ func TestGetKey(t *testing.T) {
 			input2: DNSMapping{Name: "bar", IP: net.ParseIP("192.168.0.1")},
 			equals: false,
 		},
+		{
+			// Should return different key for pairs whose concatenation is equal
+			input1: DNSMapping{Name: "foo", IP: net.ParseIP("192.168.0.1")},
+			input2: DNSMapping{Name: "foo1", IP: net.ParseIP("92.168.0.1")},
+			equals: false,
+		},
 		{
 			// Should ignore ContainerID length
 			input1: DNSMapping{Name: "foo", IP: net.ParseIP("192.168.0.1"), ContainerID: "foo"},
@@ -41,3 +47,75 @@ func TestGetKey(t *testing.T) {
 		}
 	}
 }
+
+func TestDNSContainerListGetKey(t *testing.T) {
+	mapping := DNSMapping{Name: "foo", IP: net.ParseIP("192.168.0.1"), ContainerID: "foo"}
+	list := DNSContainerList{Name: "foo", IP: net.ParseIP("192.168.0.1"), ContainerList: []string{"foo", "bar"}}
+	if string(mapping.GetKey()) != string(list.GetKey()) {
+		t.Errorf("Expected `%q` to equal `%q`", list.GetKey(), mapping.GetKey())
+	}
+}
+
+func TestGetRecordType(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    net.IP
+		expected string
+	}{
+		{
+			name:     "Should return A for an IPv4 address",
+			input:    net.ParseIP("192.168.0.1"),
+			expected: RecordTypeA,
+		},
+		{
+			name:     "Should return A for an IPv4-mapped IPv6 address",
+			input:    net.ParseIP("::ffff:192.168.0.1"),
+			expected: RecordTypeA,
+		},
+		{
+			name:     "Should return AAAA for an IPv6 address",
+			input:    net.ParseIP("2001:db8::1"),
+			expected: RecordTypeAAAA,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			output := GetRecordType(tc.input)
+			if output != tc.expected {
+				t.Errorf("Expected `%s` to have record type `%s`, got `%s`", tc.input, tc.expected, output)
+			}
+		})
+	}
+}
+
+func TestHasDNSMapping(t *testing.T) {
+	col := []*DNSMapping{
+		{Name: "foo", Type: RecordTypeA, IP: net.ParseIP("192.168.0.1"), ContainerID: "foo", Settings: RecordSettings{TTL: 120}},
+	}
+	cases := []struct {
+		name     string
+		input    *DNSMapping
+		expected bool
+	}{
+		{
+			name:     "Should ignore the settings of the mapping",
+			input:    &DNSMapping{Name: "foo", Type: RecordTypeA, IP: net.ParseIP("192.168.0.1"), ContainerID: "foo", Settings: RecordSettings{Proxied: true}},
+			expected: true,
+		},
+		{
+			name:     "Should not ignore the ContainerID of the mapping",
+			input:    &DNSMapping{Name: "foo", Type: RecordTypeA, IP: net.ParseIP("192.168.0.1"), ContainerID: "bar"},
+			expected: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			output := HasDNSMapping(col, tc.input)
+			if output != tc.expected {
+				t.Errorf("Expected HasDNSMapping to return `%t` for `%v`, got `%t`", tc.expected, tc.input, output)
+			}
+		})
+	}
+}